@@ -8,7 +8,8 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
-	"fmt"
+	"fmt"{{ if .Streaming }}
+	"io"{{ end }}
 	"os"
 	"strings"
 
@@ -92,7 +93,7 @@ func main() {
 
 	inpr := bytes.NewBufferString(inp)
 
-	{{ $Pkg := .Pkg }}
+	{{ $Pkg := .Pkg }}{{ $Name := .Name }}
 
 	var rep proto.Message
 	ctx := context.Background()
@@ -100,12 +101,73 @@ func main() {
 	switch strings.ToLower(meth) { {{ range .Methods }}
 	case "{{ .Name|lower }}"{{ if ne (.Name|lower) (.Name|hyphenize) }}, "{{ .Name|hyphenize }}"{{ end }}:
 		client := {{ $Pkg }}.NewClient(tp)
-		var req {{ $Pkg }}.{{ .InputType|base }}
+		{{ if .ClientStreaming }}var stream {{ $Pkg }}.{{ $Name }}_{{ .Name }}Client
+		stream, err = client.{{ .Name }}(ctx)
+		if err != nil {
+			break
+		}
+
+		// Each JSON value of the input is sent as a message of the stream.
+		dec := json.NewDecoder(inpr)
+		{{ if .ServerStreaming }}go func() {
+			for {
+				var req {{ $Pkg }}.{{ .InputType|base }}
+				if !readMessage(dec, &req) {
+					break
+				}
+				if err := stream.Send(&req); err == io.EOF {
+					break
+				} else if err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			if err := stream.CloseSend(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		for err == nil {
+			if rep, err = stream.Recv(); err == nil {
+				printMessage(rep)
+			}
+		}
+
+		if err == io.EOF {
+			return
+		}
+		{{ else }}for {
+			var req {{ $Pkg }}.{{ .InputType|base }}
+			if !readMessage(dec, &req) {
+				break
+			}
+			if err = stream.Send(&req); err != nil {
+				break
+			}
+		}
+
+		if err == nil || err == io.EOF {
+			rep, err = stream.CloseAndRecv()
+		}
+		{{ end }}{{ else }}var req {{ $Pkg }}.{{ .InputType|base }}
 		if err := jsonUnmarshaler.Unmarshal(inpr, &req); err != nil {
 			log.Fatalf("json: %s", err)
 		}
-		rep, err = client.{{ .Name }}(ctx, &req)
-		{{ end }}
+		{{ if .ServerStreaming }}var stream {{ $Pkg }}.{{ $Name }}_{{ .Name }}Client
+		stream, err = client.{{ .Name }}(ctx, &req)
+
+		// Each message of the stream is printed on its own line.
+		for err == nil {
+			if rep, err = stream.Recv(); err == nil {
+				printMessage(rep)
+			}
+		}
+
+		if err == io.EOF {
+			return
+		}
+		{{ else }}rep, err = client.{{ .Name }}(ctx, &req)
+		{{ end }}{{ end }}{{ end }}
 
 	default:
 		log.Fatalf("unknown method %s", meth)
@@ -117,6 +179,21 @@ func main() {
 				"code": sts.Code().String(),
 				"message": sts.Message(),
 			}
+
+			// Details are printed using their JSON mapping, such as for
+			// google.rpc.BadRequest.
+			if ds := sts.Proto().GetDetails(); len(ds) > 0 {
+				details := make([]json.RawMessage, len(ds))
+				for i, d := range ds {
+					b, err := jsonMarshaler.MarshalToString(d)
+					if err != nil {
+						log.Fatalf("error encoding error details: %s", err)
+					}
+					details[i] = json.RawMessage(b)
+				}
+				out["details"] = details
+			}
+
 			if err := json.NewEncoder(os.Stderr).Encode(out); err != nil {
 				log.Fatalf("error encoding error: %s", err)
 			}
@@ -124,9 +201,26 @@ func main() {
 		log.Fatal(err)
 	}
 
+	printMessage(rep)
+}
+
+func printMessage(rep proto.Message) {
 	if err := jsonMarshaler.Marshal(os.Stdout, rep); err != nil {
 		log.Fatalf("error encoding response: %s", err)
 	}
 	fmt.Fprint(os.Stdout, "\n")
 }
-`
+{{ if .ClientStreaming }}
+// readMessage reads the next JSON value of the input into pb. It returns
+// false at the end of the input.
+func readMessage(dec *json.Decoder, pb proto.Message) bool {
+	err := jsonUnmarshaler.UnmarshalNext(dec, pb)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		log.Fatalf("json: %s", err)
+	}
+	return true
+}
+{{ end }}`
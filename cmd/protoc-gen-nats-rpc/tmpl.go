@@ -3,10 +3,9 @@ package main
 const tmpl = `package {{ .Pkg }}
 
 import (
-	"context"
-	"os"
-	"os/signal"
-	"syscall"
+	"context"{{ if .ClientStreaming }}
+	"io"{{ end }}
+	"sync"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,21 +16,127 @@ import (
 )
 
 type Service interface {
-{{ range .Methods }}	{{ .Name }}(context.Context, *{{ .InputType | base }}) (*{{ .OutputType | base}}, error)
-{{ end }}}
+{{ range .Methods }}{{ if .ClientStreaming }}	{{ .Name }}({{ $.Name }}_{{ .Name }}Server) error
+{{ else if .ServerStreaming }}	{{ .Name }}(*{{ .InputType | base }}, {{ $.Name }}_{{ .Name }}Server) error
+{{ else }}	{{ .Name }}(context.Context, *{{ .InputType | base }}) (*{{ .OutputType | base}}, error)
+{{ end }}{{ end }}}
 
 type Client interface {
-{{ range .Methods }}	{{ .Name }}(context.Context, *{{ .InputType | base }}, ...transport.RequestOption) (*{{ .OutputType | base}}, error)
-{{ end }}}
+{{ range .Methods }}{{ if .ClientStreaming }}	{{ .Name }}(context.Context, ...transport.RequestOption) ({{ $.Name }}_{{ .Name }}Client, error)
+{{ else if .ServerStreaming }}	{{ .Name }}(context.Context, *{{ .InputType | base }}, ...transport.RequestOption) ({{ $.Name }}_{{ .Name }}Client, error)
+{{ else }}	{{ .Name }}(context.Context, *{{ .InputType | base }}, ...transport.RequestOption) (*{{ .OutputType | base}}, error)
+{{ end }}{{ end }}}
+{{ range .Methods }}{{ if .Streaming }}
+// {{ $.Name }}_{{ .Name }}Client is the client side of the {{ .Name }} stream.
+type {{ $.Name }}_{{ .Name }}Client interface {
+{{ if .ClientStreaming }}	Send(*{{ .InputType | base }}) error
+{{ end }}{{ if .ServerStreaming }}	Recv() (*{{ .OutputType | base }}, error)
+{{ end }}{{ if and .ClientStreaming .ServerStreaming }}	CloseSend() error
+{{ else if .ClientStreaming }}	CloseAndRecv() (*{{ .OutputType | base }}, error)
+{{ end }}	Context() context.Context
+}
+
+type {{ $.Name | unexport }}{{ .Name }}Client struct {
+	transport.ClientStream
+}
+{{ if .ClientStreaming }}
+func (x *{{ $.Name | unexport }}{{ .Name }}Client) Send(m *{{ .InputType | base }}) error {
+	return x.ClientStream.Send(m)
+}
+{{ end }}{{ if .ServerStreaming }}
+func (x *{{ $.Name | unexport }}{{ .Name }}Client) Recv() (*{{ .OutputType | base }}, error) {
+	var m {{ .OutputType | base }}
+	if err := x.ClientStream.Recv(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+{{ else }}
+func (x *{{ $.Name | unexport }}{{ .Name }}Client) CloseAndRecv() (*{{ .OutputType | base }}, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	var m {{ .OutputType | base }}
+	if err := x.ClientStream.Recv(&m); err != nil {
+		if err == io.EOF {
+			return nil, status.Error(codes.Internal, "{{ .Name }}: no reply received")
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+{{ end }}
+// {{ $.Name }}_{{ .Name }}Server is the server side of the {{ .Name }} stream.
+type {{ $.Name }}_{{ .Name }}Server interface {
+{{ if .ServerStreaming }}	Send(*{{ .OutputType | base }}) error
+{{ else }}	SendAndClose(*{{ .OutputType | base }}) error
+{{ end }}{{ if .ClientStreaming }}	Recv() (*{{ .InputType | base }}, error)
+{{ end }}	Context() context.Context
+}
+
+type {{ $.Name | unexport }}{{ .Name }}Server struct {
+	transport.ServerStream
+}
+{{ if .ServerStreaming }}
+func (x *{{ $.Name | unexport }}{{ .Name }}Server) Send(m *{{ .OutputType | base }}) error {
+	return x.ServerStream.Send(m)
+}
+{{ else }}
+func (x *{{ $.Name | unexport }}{{ .Name }}Server) SendAndClose(m *{{ .OutputType | base }}) error {
+	return x.ServerStream.Send(m)
+}
+{{ end }}{{ if .ClientStreaming }}
+func (x *{{ $.Name | unexport }}{{ .Name }}Server) Recv() (*{{ .InputType | base }}, error) {
+	var m {{ .InputType | base }}
+	if err := x.ServerStream.Recv(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+{{ end }}{{ end }}{{ end }}
 
 // client is an implementation of Client.
 type client struct {
-	tp transport.Transport
+	tp   transport.Transport
+	opts []transport.RequestOption
+}
+
+// options returns the options for a call. Default options are applied first,
+// then metadata set on the context, so explicit options take precedence.
+func (c *client) options(ctx context.Context, opts []transport.RequestOption) []transport.RequestOption {
+	out := make([]transport.RequestOption, 0, len(c.opts)+len(opts)+1)
+	out = append(out, c.opts...)
+
+	if md, ok := transport.OutgoingMetadataFromContext(ctx); ok {
+		out = append(out, transport.RequestMetadata(md))
+	}
+
+	return append(out, opts...)
+}
+
+{{ range .Methods }}{{ if .ClientStreaming }}func (c *client) {{ .Name }}(ctx context.Context, opts ...transport.RequestOption) ({{ $.Name }}_{{ .Name }}Client, error) {
+	stream, err := c.tp.Stream(ctx, "{{ .Topic }}", nil, c.options(ctx, opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &{{ $.Name | unexport }}{{ .Name }}Client{stream}, nil
+}
+
+{{ else if .ServerStreaming }}func (c *client) {{ .Name }}(ctx context.Context, req *{{ .InputType | base }}, opts ...transport.RequestOption) ({{ $.Name }}_{{ .Name }}Client, error) {
+	stream, err := c.tp.Stream(ctx, "{{ .Topic }}", req, c.options(ctx, opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &{{ $.Name | unexport }}{{ .Name }}Client{stream}, nil
 }
-{{ range .Methods }}func (c *client) {{ .Name }}(ctx context.Context, req *{{ .InputType | base }}, opts ...transport.RequestOption) (*{{ .OutputType | base}}, error) {
+
+{{ else }}func (c *client) {{ .Name }}(ctx context.Context, req *{{ .InputType | base }}, opts ...transport.RequestOption) (*{{ .OutputType | base}}, error) {
 	var rep {{ .OutputType | base }}
-	
-	_, err := c.tp.Request("{{ .Topic }}", req, &rep, opts...)
+
+	_, err := c.tp.Request(ctx, "{{ .Topic }}", req, &rep, c.options(ctx, opts)...)
 	if err != nil {
 		return nil, err
 	}
@@ -39,50 +144,174 @@ type client struct {
 	return &rep, nil
 }
 
-{{ end }}// NewClient creates a new {{ .Name }} client.
-func NewClient(tp transport.Transport) Client {
-	return &client{tp}
+{{ end }}{{ end }}// NewClient creates a new {{ .Name }} client. The options are applied to
+// every call, for example to add interceptors using transport.RequestInterceptors.
+func NewClient(tp transport.Transport, opts ...transport.RequestOption) Client {
+	return &client{
+		tp:   tp,
+		opts: opts,
+	}
 }
 
 type server struct {
-	tp  transport.Transport
-	svc {{ .Name }}
+	tp   transport.Transport
+	svc  {{ .Name }}
+	opts *natsrpc.ServerOptions
+
+	// shutdown is set by Shutdown or once the context of Serve is done,
+	// after which Serve does not serve.
+	mux      sync.Mutex
+	shutdown bool
+	serving  bool
+
+	// drain hands the context of Shutdown to Serve, which drains the
+	// subscriptions and sends the result to drained.
+	drain   chan context.Context
+	drained chan error
+
+	// exited is closed once Serve returns.
+	exited chan struct{}
+	once   sync.Once
+}
+
+// handle dispatches a message to the method of its subject.
+func (s *server) handle(ctx context.Context, msg *transport.Message) (proto.Message, error) {
+	switch msg.Subject { {{ range $i, $m := .Methods }}{{ if $i }}
+{{ end }}
+	case "{{.Topic}}":
+		if s.opts.Disabled["{{ .Name }}"] {
+			break
+		}
+		{{ if .ClientStreaming }}stream, ok := transport.ServerStreamFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "{{ .Name }} must be called as a stream")
+		}
+		return nil, s.svc.{{ .Name }}(&{{ $.Name | unexport }}{{ .Name }}Server{stream}){{ else }}var req {{ .InputType | base }}
+		if err := msg.Decode(&req); err != nil {
+			return nil, err
+		}
+		{{ if .ServerStreaming }}stream, ok := transport.ServerStreamFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "{{ .Name }} must be called as a stream")
+		}
+		return nil, s.svc.{{ .Name }}(&req, &{{ $.Name | unexport }}{{ .Name }}Server{stream}){{ else }}return s.svc.{{ .Name }}(ctx, &req){{ end }}{{ end }}{{ end }}
+	}
+
+	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (s *server) Serve(ctx context.Context, opts ...transport.SubscribeOption) error {
-	ctx, cancel := context.WithCancel(ctx)
+	s.mux.Lock()
+	shutdown := s.shutdown
+	s.serving = !shutdown
+	s.mux.Unlock()
+
+	if shutdown {
+		return nil
+	}
+
+	defer s.once.Do(func() {
+		close(s.exited)
+	})
+
+	// Handler contexts are cancelled when the server shuts down rather than
+	// as soon as ctx is done, so in-flight calls get the grace period.
+	hctx, cancel := natsrpc.HandlerContext(ctx)
 	defer cancel()
+	opts = append([]transport.SubscribeOption{transport.SubscribeContext(hctx)}, opts...)
 
-	var err error
-	_, err = s.tp.Subscribe("{{ .Subject }}.>", func(msg *transport.Message) (proto.Message, error) {
-		switch msg.Subject { {{ range .Methods }}
-		case "{{.Topic}}":
-			var req {{ .InputType | base }}
-			if err := msg.Decode(&req); err != nil {
-				return nil, err
-			}
-			return s.svc.{{ .Name }}(ctx, &req)
-		{{ end }}
-		default:
-			return nil, status.Error(codes.Unimplemented, "")
+	var subs []transport.Subscription
+
+	subscribe := func(subject, method string) error {
+		sub, err := s.tp.Subscribe(subject, s.handle, s.opts.SubscribeOptions(method, opts)...)
+		if err != nil {
+			return err
 		}
-	}, opts...)
+		subs = append(subs, sub)
+		return nil
+	}
+
+	var err error
+	if s.opts.Wildcard {
+		err = subscribe("{{ .Subject }}.>", "")
+	} else { {{ range .Methods }}
+		if err == nil && !s.opts.Disabled["{{ .Name }}"] {
+			err = subscribe("{{ .Topic }}", "{{ .Name }}")
+		}{{ end }}
+	}
 	if err != nil {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
 		return err
 	}
 
-	sigchan := make(chan os.Signal)
-	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-ctx.Done():
+		s.mux.Lock()
+		s.shutdown = true
+		s.mux.Unlock()
 
-	<-sigchan
+		return natsrpc.Drain(context.Background(), subs)
 
-	return nil
+	case dctx := <-s.drain:
+		s.drained <- natsrpc.Drain(dctx, subs)
+		return nil
+	}
 }
 
-func NewServer(tp transport.Transport, svc {{ .Name }}) natsrpc.Server {
+func (s *server) Shutdown(ctx context.Context) error {
+	s.mux.Lock()
+	serving := s.serving
+	s.shutdown = true
+	s.mux.Unlock()
+
+	if !serving {
+		return nil
+	}
+
+	// Serve may still be subscribing, so the subscriptions are drained
+	// by Serve once it is done.
+	select {
+	case s.drain <- ctx:
+	case <-s.exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-s.drained:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewServer creates a new {{ .Name }} server. The options are applied to the
+// subscription of every method, for example to add interceptors using
+// transport.SubscribeInterceptors.
+func NewServer(tp transport.Transport, svc {{ .Name }}, opts ...transport.SubscribeOption) natsrpc.Server {
+	return NewServerWithOptions(tp, svc, natsrpc.ServerSubscribe(opts...))
+}
+
+// NewServerWithOptions creates a new {{ .Name }} server. Each method is served
+// using its own subscription, which can be configured or disabled using
+// natsrpc.ServerMethod and natsrpc.ServerDisable.
+func NewServerWithOptions(tp transport.Transport, svc {{ .Name }}, opts ...natsrpc.ServerOption) natsrpc.Server {
 	return &server{
-		tp:  tp,
-		svc: svc,
+		tp:      tp,
+		svc:     svc,
+		opts:    natsrpc.NewServerOptions(opts...),
+		drain:   make(chan context.Context),
+		drained: make(chan error, 1),
+		exited:  make(chan struct{}),
 	}
 }
+
+// Register{{ .Name }} registers a {{ .Name }} server with the registry so it
+// is served on the transport of the registry with the other services.
+func Register{{ .Name }}(r *natsrpc.Registry, svc {{ .Name }}, opts ...natsrpc.ServerOption) {
+	r.Register(NewServerWithOptions(r.Transport(), svc, opts...))
+}
 `
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -102,6 +103,88 @@ func main() {
 		}
 		rep, err = client.Sum(ctx, &req)
 
+	case "count":
+		client := example.NewClient(tp)
+		var req example.Req
+		if err := jsonUnmarshaler.Unmarshal(inpr, &req); err != nil {
+			log.Fatalf("json: %s", err)
+		}
+		var stream example.Service_CountClient
+		stream, err = client.Count(ctx, &req)
+
+		// Each message of the stream is printed on its own line.
+		for err == nil {
+			if rep, err = stream.Recv(); err == nil {
+				printMessage(rep)
+			}
+		}
+
+		if err == io.EOF {
+			return
+		}
+
+	case "total":
+		client := example.NewClient(tp)
+		var stream example.Service_TotalClient
+		stream, err = client.Total(ctx)
+		if err != nil {
+			break
+		}
+
+		// Each JSON value of the input is sent as a message of the stream.
+		dec := json.NewDecoder(inpr)
+		for {
+			var req example.Req
+			if !readMessage(dec, &req) {
+				break
+			}
+			if err = stream.Send(&req); err != nil {
+				break
+			}
+		}
+
+		if err == nil || err == io.EOF {
+			rep, err = stream.CloseAndRecv()
+		}
+
+	case "running":
+		client := example.NewClient(tp)
+		var stream example.Service_RunningClient
+		stream, err = client.Running(ctx)
+		if err != nil {
+			break
+		}
+
+		// Each JSON value of the input is sent as a message of the stream.
+		dec := json.NewDecoder(inpr)
+		go func() {
+			for {
+				var req example.Req
+				if !readMessage(dec, &req) {
+					break
+				}
+				if err := stream.Send(&req); err == io.EOF {
+					break
+				} else if err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			if err := stream.CloseSend(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		for err == nil {
+			if rep, err = stream.Recv(); err == nil {
+				printMessage(rep)
+			}
+		}
+
+		if err == io.EOF {
+			return
+		}
+
 	default:
 		log.Fatalf("unknown method %s", meth)
 	}
@@ -112,6 +195,21 @@ func main() {
 				"code":    sts.Code().String(),
 				"message": sts.Message(),
 			}
+
+			// Details are printed using their JSON mapping, such as for
+			// google.rpc.BadRequest.
+			if ds := sts.Proto().GetDetails(); len(ds) > 0 {
+				details := make([]json.RawMessage, len(ds))
+				for i, d := range ds {
+					b, err := jsonMarshaler.MarshalToString(d)
+					if err != nil {
+						log.Fatalf("error encoding error details: %s", err)
+					}
+					details[i] = json.RawMessage(b)
+				}
+				out["details"] = details
+			}
+
 			if err := json.NewEncoder(os.Stderr).Encode(out); err != nil {
 				log.Fatalf("error encoding error: %s", err)
 			}
@@ -119,8 +217,25 @@ func main() {
 		log.Fatal(err)
 	}
 
+	printMessage(rep)
+}
+
+func printMessage(rep proto.Message) {
 	if err := jsonMarshaler.Marshal(os.Stdout, rep); err != nil {
 		log.Fatalf("error encoding response: %s", err)
 	}
 	fmt.Fprint(os.Stdout, "\n")
 }
+
+// readMessage reads the next JSON value of the input into pb. It returns
+// false at the end of the input.
+func readMessage(dec *json.Decoder, pb proto.Message) bool {
+	err := jsonUnmarshaler.UnmarshalNext(dec, pb)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		log.Fatalf("json: %s", err)
+	}
+	return true
+}
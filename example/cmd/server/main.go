@@ -4,6 +4,8 @@ import (
 	"context"
 	"flag"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/chop-dbhi/nats-rpc/example"
 
@@ -40,7 +42,10 @@ func main() {
 	// Initialize the service.
 	svc := example.NewService()
 
-	ctx := context.Background()
+	// Serve until interrupted. In-flight calls are then given time to
+	// finish before exiting.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
 	// Initialize a server and serve the service.
 	srv := example.NewServer(tp, svc)
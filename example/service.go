@@ -1,6 +1,9 @@
 package example
 
-import "context"
+import (
+	"context"
+	"io"
+)
 
 type service struct{}
 
@@ -10,6 +13,52 @@ func (s *service) Sum(ctx context.Context, req *Req) (*Rep, error) {
 	}, nil
 }
 
+// Count streams the integers from left to right.
+func (s *service) Count(req *Req, stream Service_CountServer) error {
+	for i := req.Left; i <= req.Right; i++ {
+		if err := stream.Send(&Rep{Sum: i}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Total sums all requests of the stream.
+func (s *service) Total(stream Service_TotalServer) error {
+	var sum int32
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&Rep{Sum: sum})
+		}
+		if err != nil {
+			return err
+		}
+
+		sum += req.Left + req.Right
+	}
+}
+
+// Running replies to each request with the sum of the stream so far.
+func (s *service) Running(stream Service_RunningServer) error {
+	var sum int32
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		sum += req.Left + req.Right
+		if err := stream.Send(&Rep{Sum: sum}); err != nil {
+			return err
+		}
+	}
+}
+
 func NewService() Service {
 	return &service{}
 }
@@ -77,14 +77,16 @@ func init() {
 func init() { proto.RegisterFile("service.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 135 bytes of a gzipped FileDescriptorProto
+	// 172 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2d, 0x4e, 0x2d, 0x2a,
 	0xcb, 0x4c, 0x4e, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x4f, 0xad, 0x48, 0xcc, 0x2d,
 	0xc8, 0x49, 0x55, 0xd2, 0xe7, 0x62, 0x0e, 0x4a, 0x2d, 0x14, 0x12, 0xe2, 0x62, 0xc9, 0x49, 0x4d,
 	0x2b, 0x91, 0x60, 0x54, 0x60, 0xd4, 0x60, 0x0d, 0x02, 0xb3, 0x85, 0x44, 0xb8, 0x58, 0x8b, 0x32,
 	0xd3, 0x33, 0x4a, 0x24, 0x98, 0xc0, 0x82, 0x10, 0x8e, 0x92, 0x38, 0x48, 0x43, 0x81, 0x90, 0x00,
-	0x17, 0x73, 0x71, 0x69, 0x2e, 0x54, 0x3d, 0x88, 0x69, 0xa4, 0xc3, 0xc5, 0x1e, 0x0c, 0xb1, 0x43,
-	0x48, 0x91, 0x8b, 0x39, 0xb8, 0x34, 0x57, 0x88, 0x47, 0x0f, 0x6a, 0x8b, 0x5e, 0x50, 0x6a, 0xa1,
-	0x14, 0x32, 0xaf, 0x20, 0x89, 0x0d, 0xec, 0x0e, 0x63, 0x40, 0x00, 0x00, 0x00, 0xff, 0xff, 0xac,
-	0x7c, 0xd2, 0x6d, 0x98, 0x00, 0x00, 0x00,
+	0x17, 0x73, 0x71, 0x69, 0x2e, 0x54, 0x3d, 0x88, 0x69, 0xb4, 0x94, 0x91, 0x8b, 0x3d, 0x18, 0x62,
+	0x89, 0x90, 0x22, 0x17, 0x73, 0x70, 0x69, 0xae, 0x10, 0x8f, 0x1e, 0xd4, 0x1a, 0xbd, 0xa0, 0xd4,
+	0x42, 0x29, 0x64, 0x5e, 0x81, 0x90, 0x2a, 0x17, 0xab, 0x73, 0x7e, 0x69, 0x5e, 0x09, 0x3e, 0x45,
+	0x06, 0x8c, 0x20, 0x65, 0x21, 0xf9, 0x25, 0x89, 0x39, 0xf8, 0x94, 0x69, 0x30, 0x0a, 0x69, 0x72,
+	0xb1, 0x07, 0x95, 0xe6, 0xe5, 0x65, 0xe6, 0xa5, 0xe3, 0x57, 0x68, 0xc0, 0x98, 0xc4, 0x06, 0x0e,
+	0x01, 0x63, 0xc0, 0x00, 0x03, 0x1c, 0xad, 0x16, 0x12, 0x01, 0x00, 0x00,
 }
@@ -2,9 +2,8 @@ package example
 
 import (
 	"context"
-	"os"
-	"os/signal"
-	"syscall"
+	"io"
+	"sync"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -16,21 +15,173 @@ import (
 
 type Service interface {
 	Sum(context.Context, *Req) (*Rep, error)
+	Count(*Req, Service_CountServer) error
+	Total(Service_TotalServer) error
+	Running(Service_RunningServer) error
 }
 
 type Client interface {
 	Sum(context.Context, *Req, ...transport.RequestOption) (*Rep, error)
+	Count(context.Context, *Req, ...transport.RequestOption) (Service_CountClient, error)
+	Total(context.Context, ...transport.RequestOption) (Service_TotalClient, error)
+	Running(context.Context, ...transport.RequestOption) (Service_RunningClient, error)
+}
+
+// Service_CountClient is the client side of the Count stream.
+type Service_CountClient interface {
+	Recv() (*Rep, error)
+	Context() context.Context
+}
+
+type serviceCountClient struct {
+	transport.ClientStream
+}
+
+func (x *serviceCountClient) Recv() (*Rep, error) {
+	var m Rep
+	if err := x.ClientStream.Recv(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Service_CountServer is the server side of the Count stream.
+type Service_CountServer interface {
+	Send(*Rep) error
+	Context() context.Context
+}
+
+type serviceCountServer struct {
+	transport.ServerStream
+}
+
+func (x *serviceCountServer) Send(m *Rep) error {
+	return x.ServerStream.Send(m)
+}
+
+// Service_TotalClient is the client side of the Total stream.
+type Service_TotalClient interface {
+	Send(*Req) error
+	CloseAndRecv() (*Rep, error)
+	Context() context.Context
+}
+
+type serviceTotalClient struct {
+	transport.ClientStream
+}
+
+func (x *serviceTotalClient) Send(m *Req) error {
+	return x.ClientStream.Send(m)
+}
+
+func (x *serviceTotalClient) CloseAndRecv() (*Rep, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	var m Rep
+	if err := x.ClientStream.Recv(&m); err != nil {
+		if err == io.EOF {
+			return nil, status.Error(codes.Internal, "Total: no reply received")
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Service_TotalServer is the server side of the Total stream.
+type Service_TotalServer interface {
+	SendAndClose(*Rep) error
+	Recv() (*Req, error)
+	Context() context.Context
+}
+
+type serviceTotalServer struct {
+	transport.ServerStream
+}
+
+func (x *serviceTotalServer) SendAndClose(m *Rep) error {
+	return x.ServerStream.Send(m)
+}
+
+func (x *serviceTotalServer) Recv() (*Req, error) {
+	var m Req
+	if err := x.ServerStream.Recv(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Service_RunningClient is the client side of the Running stream.
+type Service_RunningClient interface {
+	Send(*Req) error
+	Recv() (*Rep, error)
+	CloseSend() error
+	Context() context.Context
+}
+
+type serviceRunningClient struct {
+	transport.ClientStream
+}
+
+func (x *serviceRunningClient) Send(m *Req) error {
+	return x.ClientStream.Send(m)
+}
+
+func (x *serviceRunningClient) Recv() (*Rep, error) {
+	var m Rep
+	if err := x.ClientStream.Recv(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Service_RunningServer is the server side of the Running stream.
+type Service_RunningServer interface {
+	Send(*Rep) error
+	Recv() (*Req, error)
+	Context() context.Context
+}
+
+type serviceRunningServer struct {
+	transport.ServerStream
+}
+
+func (x *serviceRunningServer) Send(m *Rep) error {
+	return x.ServerStream.Send(m)
+}
+
+func (x *serviceRunningServer) Recv() (*Req, error) {
+	var m Req
+	if err := x.ServerStream.Recv(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
 }
 
 // client is an implementation of Client.
 type client struct {
-	tp transport.Transport
+	tp   transport.Transport
+	opts []transport.RequestOption
+}
+
+// options returns the options for a call. Default options are applied first,
+// then metadata set on the context, so explicit options take precedence.
+func (c *client) options(ctx context.Context, opts []transport.RequestOption) []transport.RequestOption {
+	out := make([]transport.RequestOption, 0, len(c.opts)+len(opts)+1)
+	out = append(out, c.opts...)
+
+	if md, ok := transport.OutgoingMetadataFromContext(ctx); ok {
+		out = append(out, transport.RequestMetadata(md))
+	}
+
+	return append(out, opts...)
 }
 
 func (c *client) Sum(ctx context.Context, req *Req, opts ...transport.RequestOption) (*Rep, error) {
 	var rep Rep
 
-	_, err := c.tp.Request("example.Sum", req, &rep, opts...)
+	_, err := c.tp.Request(ctx, "example.Sum", req, &rep, c.options(ctx, opts)...)
 	if err != nil {
 		return nil, err
 	}
@@ -38,49 +189,234 @@ func (c *client) Sum(ctx context.Context, req *Req, opts ...transport.RequestOpt
 	return &rep, nil
 }
 
-// NewClient creates a new Service client.
-func NewClient(tp transport.Transport) Client {
-	return &client{tp}
+func (c *client) Count(ctx context.Context, req *Req, opts ...transport.RequestOption) (Service_CountClient, error) {
+	stream, err := c.tp.Stream(ctx, "example.Count", req, c.options(ctx, opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serviceCountClient{stream}, nil
+}
+
+func (c *client) Total(ctx context.Context, opts ...transport.RequestOption) (Service_TotalClient, error) {
+	stream, err := c.tp.Stream(ctx, "example.Total", nil, c.options(ctx, opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serviceTotalClient{stream}, nil
+}
+
+func (c *client) Running(ctx context.Context, opts ...transport.RequestOption) (Service_RunningClient, error) {
+	stream, err := c.tp.Stream(ctx, "example.Running", nil, c.options(ctx, opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serviceRunningClient{stream}, nil
+}
+
+// NewClient creates a new Service client. The options are applied to
+// every call, for example to add interceptors using transport.RequestInterceptors.
+func NewClient(tp transport.Transport, opts ...transport.RequestOption) Client {
+	return &client{
+		tp:   tp,
+		opts: opts,
+	}
 }
 
 type server struct {
-	tp  transport.Transport
-	svc Service
+	tp   transport.Transport
+	svc  Service
+	opts *natsrpc.ServerOptions
+
+	// shutdown is set by Shutdown or once the context of Serve is done,
+	// after which Serve does not serve.
+	mux      sync.Mutex
+	shutdown bool
+	serving  bool
+
+	// drain hands the context of Shutdown to Serve, which drains the
+	// subscriptions and sends the result to drained.
+	drain   chan context.Context
+	drained chan error
+
+	// exited is closed once Serve returns.
+	exited chan struct{}
+	once   sync.Once
+}
+
+// handle dispatches a message to the method of its subject.
+func (s *server) handle(ctx context.Context, msg *transport.Message) (proto.Message, error) {
+	switch msg.Subject {
+	case "example.Sum":
+		if s.opts.Disabled["Sum"] {
+			break
+		}
+		var req Req
+		if err := msg.Decode(&req); err != nil {
+			return nil, err
+		}
+		return s.svc.Sum(ctx, &req)
+
+	case "example.Count":
+		if s.opts.Disabled["Count"] {
+			break
+		}
+		var req Req
+		if err := msg.Decode(&req); err != nil {
+			return nil, err
+		}
+		stream, ok := transport.ServerStreamFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "Count must be called as a stream")
+		}
+		return nil, s.svc.Count(&req, &serviceCountServer{stream})
+
+	case "example.Total":
+		if s.opts.Disabled["Total"] {
+			break
+		}
+		stream, ok := transport.ServerStreamFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "Total must be called as a stream")
+		}
+		return nil, s.svc.Total(&serviceTotalServer{stream})
+
+	case "example.Running":
+		if s.opts.Disabled["Running"] {
+			break
+		}
+		stream, ok := transport.ServerStreamFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "Running must be called as a stream")
+		}
+		return nil, s.svc.Running(&serviceRunningServer{stream})
+	}
+
+	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (s *server) Serve(ctx context.Context, opts ...transport.SubscribeOption) error {
-	ctx, cancel := context.WithCancel(ctx)
+	s.mux.Lock()
+	shutdown := s.shutdown
+	s.serving = !shutdown
+	s.mux.Unlock()
+
+	if shutdown {
+		return nil
+	}
+
+	defer s.once.Do(func() {
+		close(s.exited)
+	})
+
+	// Handler contexts are cancelled when the server shuts down rather than
+	// as soon as ctx is done, so in-flight calls get the grace period.
+	hctx, cancel := natsrpc.HandlerContext(ctx)
 	defer cancel()
+	opts = append([]transport.SubscribeOption{transport.SubscribeContext(hctx)}, opts...)
+
+	var subs []transport.Subscription
+
+	subscribe := func(subject, method string) error {
+		sub, err := s.tp.Subscribe(subject, s.handle, s.opts.SubscribeOptions(method, opts)...)
+		if err != nil {
+			return err
+		}
+		subs = append(subs, sub)
+		return nil
+	}
 
 	var err error
-	_, err = s.tp.Subscribe("example.>", func(msg *transport.Message) (proto.Message, error) {
-		switch msg.Subject {
-		case "example.Sum":
-			var req Req
-			if err := msg.Decode(&req); err != nil {
-				return nil, err
-			}
-			return s.svc.Sum(ctx, &req)
-
-		default:
-			return nil, status.Error(codes.Unimplemented, "")
-		}
-	}, opts...)
+	if s.opts.Wildcard {
+		err = subscribe("example.>", "")
+	} else {
+		if err == nil && !s.opts.Disabled["Sum"] {
+			err = subscribe("example.Sum", "Sum")
+		}
+		if err == nil && !s.opts.Disabled["Count"] {
+			err = subscribe("example.Count", "Count")
+		}
+		if err == nil && !s.opts.Disabled["Total"] {
+			err = subscribe("example.Total", "Total")
+		}
+		if err == nil && !s.opts.Disabled["Running"] {
+			err = subscribe("example.Running", "Running")
+		}
+	}
 	if err != nil {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
 		return err
 	}
 
-	sigchan := make(chan os.Signal)
-	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-ctx.Done():
+		s.mux.Lock()
+		s.shutdown = true
+		s.mux.Unlock()
+
+		return natsrpc.Drain(context.Background(), subs)
+
+	case dctx := <-s.drain:
+		s.drained <- natsrpc.Drain(dctx, subs)
+		return nil
+	}
+}
+
+func (s *server) Shutdown(ctx context.Context) error {
+	s.mux.Lock()
+	serving := s.serving
+	s.shutdown = true
+	s.mux.Unlock()
+
+	if !serving {
+		return nil
+	}
+
+	// Serve may still be subscribing, so the subscriptions are drained
+	// by Serve once it is done.
+	select {
+	case s.drain <- ctx:
+	case <-s.exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	<-sigchan
+	select {
+	case err := <-s.drained:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	return nil
+// NewServer creates a new Service server. The options are applied to the
+// subscription of every method, for example to add interceptors using
+// transport.SubscribeInterceptors.
+func NewServer(tp transport.Transport, svc Service, opts ...transport.SubscribeOption) natsrpc.Server {
+	return NewServerWithOptions(tp, svc, natsrpc.ServerSubscribe(opts...))
 }
 
-func NewServer(tp transport.Transport, svc Service) natsrpc.Server {
+// NewServerWithOptions creates a new Service server. Each method is served
+// using its own subscription, which can be configured or disabled using
+// natsrpc.ServerMethod and natsrpc.ServerDisable.
+func NewServerWithOptions(tp transport.Transport, svc Service, opts ...natsrpc.ServerOption) natsrpc.Server {
 	return &server{
-		tp:  tp,
-		svc: svc,
+		tp:      tp,
+		svc:     svc,
+		opts:    natsrpc.NewServerOptions(opts...),
+		drain:   make(chan context.Context),
+		drained: make(chan error, 1),
+		exited:  make(chan struct{}),
 	}
 }
+
+// RegisterService registers a Service server with the registry so it
+// is served on the transport of the registry with the other services.
+func RegisterService(r *natsrpc.Registry, svc Service, opts ...natsrpc.ServerOption) {
+	r.Register(NewServerWithOptions(r.Transport(), svc, opts...))
+}
@@ -30,6 +30,12 @@ var (
 		"hyphenize": func(s string) string {
 			return strings.ToLower(strings.Join(camelRegexp.FindAllString(s, -1), "-"))
 		},
+		"unexport": func(s string) string {
+			if s == "" {
+				return s
+			}
+			return strings.ToLower(s[:1]) + s[1:]
+		},
 	}
 )
 
@@ -90,11 +96,39 @@ type service struct {
 	Methods []*method
 }
 
+// Streaming returns true if any method of the service streams.
+func (s *service) Streaming() bool {
+	for _, m := range s.Methods {
+		if m.Streaming() {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientStreaming returns true if any method of the service receives a
+// stream from the client.
+func (s *service) ClientStreaming() bool {
+	for _, m := range s.Methods {
+		if m.ClientStreaming {
+			return true
+		}
+	}
+	return false
+}
+
 type method struct {
-	Name       string
-	Topic      string
-	InputType  string
-	OutputType string
+	Name            string
+	Topic           string
+	InputType       string
+	OutputType      string
+	ClientStreaming bool
+	ServerStreaming bool
+}
+
+// Streaming returns true if the client, server or both stream messages.
+func (m *method) Streaming() bool {
+	return m.ClientStreaming || m.ServerStreaming
 }
 
 type subjectParams struct {
@@ -180,10 +214,12 @@ func ParseFile(in *descriptor.FileDescriptorProto, tmpl string, opts Options) (*
 
 	for _, m := range sp.Method {
 		sd.Methods = append(sd.Methods, &method{
-			Name:       m.GetName(),
-			Topic:      fmt.Sprintf("%s.%s", subject, m.GetName()),
-			InputType:  m.GetInputType(),
-			OutputType: m.GetOutputType(),
+			Name:            m.GetName(),
+			Topic:           fmt.Sprintf("%s.%s", subject, m.GetName()),
+			InputType:       m.GetInputType(),
+			OutputType:      m.GetOutputType(),
+			ClientStreaming: m.GetClientStreaming(),
+			ServerStreaming: m.GetServerStreaming(),
 		})
 	}
 
@@ -0,0 +1,123 @@
+package natsrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/chop-dbhi/nats-rpc/transport"
+)
+
+// Registry serves multiple services on one transport with a shared
+// lifecycle. Generated packages provide a Register function adding their
+// service, for example:
+//
+//	r := natsrpc.NewRegistry(tp, transport.SubscribeInterceptors(logging))
+//	example.RegisterService(r, example.NewService())
+//	err := r.Serve(ctx)
+type Registry struct {
+	tp   transport.Transport
+	opts []transport.SubscribeOption
+
+	mux     sync.Mutex
+	servers []Server
+
+	// done is closed by Shutdown.
+	done chan struct{}
+	once sync.Once
+}
+
+// NewRegistry returns a registry serving on the transport. The options, such
+// as interceptors, are applied to the subscriptions of every service.
+func NewRegistry(tp transport.Transport, opts ...transport.SubscribeOption) *Registry {
+	return &Registry{
+		tp:   tp,
+		opts: opts,
+		done: make(chan struct{}),
+	}
+}
+
+// Transport returns the transport services are served on.
+func (r *Registry) Transport() transport.Transport {
+	return r.tp
+}
+
+// Register adds a server to the registry. It must be called before Serve.
+func (r *Registry) Register(s Server) {
+	r.mux.Lock()
+	r.servers = append(r.servers, s)
+	r.mux.Unlock()
+}
+
+// Serve serves all registered services and blocks until the context is
+// cancelled or Shutdown is called. The options are applied after those of
+// the registry. If a service fails to serve, the others are stopped and the
+// first error is returned. Without services, it only blocks. Once Shutdown
+// was called, Serve returns without serving.
+func (r *Registry) Serve(ctx context.Context, opts ...transport.SubscribeOption) error {
+	r.mux.Lock()
+	servers := make([]Server, len(r.servers))
+	copy(servers, r.servers)
+	r.mux.Unlock()
+
+	if len(servers) == 0 {
+		select {
+		case <-ctx.Done():
+		case <-r.done:
+		}
+		return nil
+	}
+
+	sopts := make([]transport.SubscribeOption, 0, len(r.opts)+len(opts))
+	sopts = append(sopts, r.opts...)
+	sopts = append(sopts, opts...)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s Server) {
+			errs <- s.Serve(ctx, sopts...)
+		}(s)
+	}
+
+	var err error
+	for range servers {
+		if serr := <-errs; serr != nil && err == nil {
+			err = serr
+			cancel()
+		}
+	}
+
+	return err
+}
+
+// Shutdown shuts down all registered services concurrently, waiting for
+// in-flight calls to finish or the context to be done. The first error is
+// returned.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.mux.Lock()
+	servers := make([]Server, len(r.servers))
+	copy(servers, r.servers)
+	r.mux.Unlock()
+
+	r.once.Do(func() {
+		close(r.done)
+	})
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s Server) {
+			errs <- s.Shutdown(ctx)
+		}(s)
+	}
+
+	var err error
+	for range servers {
+		if serr := <-errs; serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return err
+}
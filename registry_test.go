@@ -0,0 +1,143 @@
+package natsrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chop-dbhi/nats-rpc"
+	"github.com/chop-dbhi/nats-rpc/example"
+	"github.com/chop-dbhi/nats-rpc/transport"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestRegistry(t *testing.T) {
+	tp := transport.NewMemory()
+	defer tp.Close()
+
+	var calls int
+	counter := func(ctx context.Context, msg *transport.Message, hdlr transport.Handler) (proto.Message, error) {
+		calls++
+		return hdlr(ctx, msg)
+	}
+
+	r := natsrpc.NewRegistry(tp, transport.SubscribeInterceptors(counter))
+	example.RegisterService(r, example.NewService())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Serve(context.Background())
+	}()
+
+	client := example.NewClient(tp)
+
+	// Wait for the service to be subscribed.
+	var (
+		rep *example.Rep
+		err error
+	)
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		rep, err = client.Sum(ctx, &example.Req{Left: 1, Right: 2})
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Sum != 3 {
+		t.Errorf("expected 3, got %d", rep.Sum)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the registry interceptor to be called once, got %d", calls)
+	}
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("expected serve to return without error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected serve to return after shutdown")
+	}
+}
+
+func TestRegistryShutdownBeforeServe(t *testing.T) {
+	tp := newSubscribeTransport()
+	defer tp.Close()
+
+	r := natsrpc.NewRegistry(tp)
+	example.RegisterService(r, example.NewService())
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Serve(context.Background())
+	}()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("expected serve to return without error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected serve to return after shutdown")
+	}
+
+	select {
+	case sub := <-tp.subscribed:
+		t.Errorf("expected no subscriptions, got %s", sub)
+	default:
+	}
+}
+
+func TestRegistryEmpty(t *testing.T) {
+	tp := transport.NewMemory()
+	defer tp.Close()
+
+	r := natsrpc.NewRegistry(tp)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Serve(context.Background())
+	}()
+
+	// Serving nothing still blocks until shutdown.
+	select {
+	case err := <-errs:
+		t.Fatalf("expected serve to block, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("expected serve to return without error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected serve to return after shutdown")
+	}
+
+	// Cancelling the context returns as well.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := natsrpc.NewRegistry(tp).Serve(ctx); err != nil {
+		t.Errorf("expected serve to return without error, got %v", err)
+	}
+}
@@ -2,10 +2,152 @@ package natsrpc
 
 import (
 	"context"
+	"time"
 
 	"github.com/chop-dbhi/nats-rpc/transport"
 )
 
 type Server interface {
+	// Serve subscribes the service and blocks until the context is
+	// cancelled or Shutdown is called. Once the context is cancelled, the
+	// subscription is drained: in-flight calls are given the grace period
+	// of the subscription to finish, see transport.SubscribeGracePeriod.
+	// Handler contexts carry the values of the context, see
+	// HandlerContext. Serve returns nil without serving once Shutdown was
+	// called or the context was cancelled.
 	Serve(context.Context, ...transport.SubscribeOption) error
+
+	// Shutdown stops accepting calls and waits for in-flight calls to
+	// finish or the context to be done, after which Serve returns. It may
+	// be called before or while Serve subscribes, in which case it waits
+	// for Serve to subscribe and then drains the subscriptions.
+	Shutdown(context.Context) error
+}
+
+// ServerOptions are the options of a generated server.
+type ServerOptions struct {
+	// Subscribe options applied to the subscription of every method.
+	Subscribe []transport.SubscribeOption
+
+	// Methods maps method names to subscribe options applied after those
+	// of every method, such as a queue group.
+	Methods map[string][]transport.SubscribeOption
+
+	// Disabled methods are not served.
+	Disabled map[string]bool
+
+	// Wildcard serves all methods using a single subscription to the
+	// subject prefix of the service. Method options are not applied.
+	Wildcard bool
+}
+
+type ServerOption func(*ServerOptions)
+
+// ServerSubscribe adds subscribe options applied to every method.
+func ServerSubscribe(opts ...transport.SubscribeOption) ServerOption {
+	return func(o *ServerOptions) {
+		o.Subscribe = append(o.Subscribe, opts...)
+	}
+}
+
+// ServerMethod adds subscribe options applied to a single method.
+func ServerMethod(method string, opts ...transport.SubscribeOption) ServerOption {
+	return func(o *ServerOptions) {
+		if o.Methods == nil {
+			o.Methods = make(map[string][]transport.SubscribeOption)
+		}
+		o.Methods[method] = append(o.Methods[method], opts...)
+	}
+}
+
+// ServerDisable disables methods so they are not served. Requests to them
+// fail or, with a wildcard subscription, are replied to with
+// codes.Unimplemented.
+func ServerDisable(methods ...string) ServerOption {
+	return func(o *ServerOptions) {
+		if o.Disabled == nil {
+			o.Disabled = make(map[string]bool)
+		}
+		for _, m := range methods {
+			o.Disabled[m] = true
+		}
+	}
+}
+
+// ServerWildcard serves all methods using a single wildcard subscription
+// rather than one subscription per method.
+func ServerWildcard() ServerOption {
+	return func(o *ServerOptions) {
+		o.Wildcard = true
+	}
+}
+
+// NewServerOptions returns the options with opts applied.
+func NewServerOptions(opts ...ServerOption) *ServerOptions {
+	o := &ServerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// SubscribeOptions returns the subscribe options of a method. The options of
+// every method are applied first, then extra, such as those passed to Serve,
+// then those of the method.
+func (o *ServerOptions) SubscribeOptions(method string, extra []transport.SubscribeOption) []transport.SubscribeOption {
+	mopts := o.Methods[method]
+
+	opts := make([]transport.SubscribeOption, 0, len(o.Subscribe)+len(extra)+len(mopts))
+	opts = append(opts, o.Subscribe...)
+	opts = append(opts, extra...)
+	return append(opts, mopts...)
+}
+
+// HandlerContext returns the parent of the handler contexts of a server
+// served with ctx. It carries the values of ctx but is only cancelled by
+// cancel, so in-flight calls keep running for the grace period of the
+// subscriptions once ctx is done.
+func HandlerContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(valueContext{ctx})
+}
+
+// valueContext carries the values of its parent without its deadline or
+// cancellation.
+type valueContext struct {
+	parent context.Context
+}
+
+func (valueContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (valueContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueContext) Err() error {
+	return nil
+}
+
+func (c valueContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// Drain drains the subscriptions concurrently and returns the first error.
+func Drain(ctx context.Context, subs []transport.Subscription) error {
+	errs := make(chan error, len(subs))
+	for _, sub := range subs {
+		go func(sub transport.Subscription) {
+			errs <- sub.Drain(ctx)
+		}(sub)
+	}
+
+	var err error
+	for range subs {
+		if serr := <-errs; serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return err
 }
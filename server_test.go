@@ -0,0 +1,304 @@
+package natsrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/chop-dbhi/nats-rpc"
+	"github.com/chop-dbhi/nats-rpc/example"
+	"github.com/chop-dbhi/nats-rpc/transport"
+	"github.com/golang/protobuf/proto"
+)
+
+// subscribeTransport is a transport reporting the subjects subscribed to.
+type subscribeTransport struct {
+	transport.Transport
+	subscribed chan string
+}
+
+func newSubscribeTransport() *subscribeTransport {
+	return &subscribeTransport{
+		Transport:  transport.NewMemory(),
+		subscribed: make(chan string, 16),
+	}
+}
+
+func (tp *subscribeTransport) Subscribe(sub string, hdlr transport.Handler, opts ...transport.SubscribeOption) (transport.Subscription, error) {
+	s, err := tp.Transport.Subscribe(sub, hdlr, opts...)
+	if err == nil {
+		tp.subscribed <- sub
+	}
+	return s, err
+}
+
+// serve serves the server until the test ends, once the subjects are
+// subscribed to.
+func serve(t *testing.T, tp *subscribeTransport, srv natsrpc.Server, subjects ...string) {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(context.Background())
+	}()
+
+	t.Cleanup(func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	})
+
+	for _, want := range subjects {
+		select {
+		case sub := <-tp.subscribed:
+			if sub != want {
+				t.Fatalf("expected subscription to %s, got %s", want, sub)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected subscription to %s", want)
+		}
+	}
+}
+
+func TestServerMethodOptions(t *testing.T) {
+	tp := newSubscribeTransport()
+	defer tp.Close()
+
+	var calls []string
+	record := func(ctx context.Context, msg *transport.Message, hdlr transport.Handler) (proto.Message, error) {
+		calls = append(calls, msg.Subject)
+		return hdlr(ctx, msg)
+	}
+
+	serve(t, tp, example.NewServerWithOptions(tp, example.NewService(),
+		natsrpc.ServerMethod("Sum", transport.SubscribeInterceptors(record)),
+		natsrpc.ServerDisable("Count"),
+	), "example.Sum", "example.Total", "example.Running")
+
+	client := example.NewClient(tp)
+	ctx := context.Background()
+
+	if _, err := client.Sum(ctx, &example.Req{Left: 1, Right: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 1 || calls[0] != "example.Sum" {
+		t.Errorf("expected method interceptor to be called for Sum only, got %v", calls)
+	}
+
+	// Disabled methods are not subscribed.
+	stream, err := client.Count(ctx, &example.Req{Left: 1, Right: 2}, transport.RequestTimeout(50*time.Millisecond))
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected disabled method to time out, got %v", err)
+	}
+}
+
+func TestServerWildcard(t *testing.T) {
+	tp := newSubscribeTransport()
+	defer tp.Close()
+
+	serve(t, tp, example.NewServerWithOptions(tp, example.NewService(),
+		natsrpc.ServerWildcard(),
+		natsrpc.ServerDisable("Sum"),
+	), "example.>")
+
+	client := example.NewClient(tp)
+
+	_, err := client.Sum(context.Background(), &example.Req{Left: 1, Right: 2})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected disabled method to be unimplemented, got %v", err)
+	}
+
+	// Subjects under the prefix which are not methods are also handled.
+	_, err = tp.Request(context.Background(), "example.Unknown", nil, nil)
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected unknown method to be unimplemented, got %v", err)
+	}
+}
+
+func TestServerShutdownBeforeServe(t *testing.T) {
+	tp := newSubscribeTransport()
+	defer tp.Close()
+
+	srv := example.NewServer(tp, example.NewService())
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(context.Background())
+	}()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("expected serve to return without error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected serve to return after shutdown")
+	}
+
+	select {
+	case sub := <-tp.subscribed:
+		t.Errorf("expected no subscriptions, got %s", sub)
+	default:
+	}
+}
+
+func TestServerServeAfterShutdown(t *testing.T) {
+	tp := newSubscribeTransport()
+	defer tp.Close()
+
+	srv := example.NewServerWithOptions(tp, example.NewService(), natsrpc.ServerWildcard())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(context.Background())
+	}()
+
+	<-tp.subscribed
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// The server is not served again.
+	if err := srv.Serve(context.Background()); err != nil {
+		t.Errorf("expected serve to return without error, got %v", err)
+	}
+
+	select {
+	case sub := <-tp.subscribed:
+		t.Errorf("expected no subscriptions, got %s", sub)
+	default:
+	}
+
+	client := example.NewClient(tp)
+
+	_, err := client.Sum(context.Background(), &example.Req{Left: 1, Right: 2}, transport.RequestTimeout(50*time.Millisecond))
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected shut down server not to reply, got %v", err)
+	}
+}
+
+// blockingTransport is a transport whose subscriptions are made once
+// released.
+type blockingTransport struct {
+	*subscribeTransport
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (tp *blockingTransport) Subscribe(sub string, hdlr transport.Handler, opts ...transport.SubscribeOption) (transport.Subscription, error) {
+	tp.entered <- struct{}{}
+	<-tp.release
+	return tp.subscribeTransport.Subscribe(sub, hdlr, opts...)
+}
+
+func TestServerShutdownWhileSubscribing(t *testing.T) {
+	tp := &blockingTransport{
+		subscribeTransport: newSubscribeTransport(),
+		entered:            make(chan struct{}, 1),
+		release:            make(chan struct{}),
+	}
+	defer tp.Close()
+
+	srv := example.NewServerWithOptions(tp, example.NewService(), natsrpc.ServerWildcard())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(context.Background())
+	}()
+
+	<-tp.entered
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- srv.Shutdown(context.Background())
+	}()
+
+	// Shutdown waits for Serve to subscribe and drain the subscriptions.
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected shutdown to wait for serve, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(tp.release)
+
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	client := example.NewClient(tp)
+
+	_, err := client.Sum(context.Background(), &example.Req{Left: 1, Right: 2}, transport.RequestTimeout(50*time.Millisecond))
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected shut down server not to reply, got %v", err)
+	}
+}
+
+type contextKey struct{}
+
+func TestServerContext(t *testing.T) {
+	tp := newSubscribeTransport()
+	defer tp.Close()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey{}, "value"))
+
+	values := make(chan interface{}, 1)
+	check := func(ctx context.Context, msg *transport.Message, hdlr transport.Handler) (proto.Message, error) {
+		values <- ctx.Value(contextKey{})
+		return hdlr(ctx, msg)
+	}
+
+	srv := example.NewServerWithOptions(tp, example.NewService(), natsrpc.ServerWildcard())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ctx, transport.SubscribeInterceptors(check))
+	}()
+
+	<-tp.subscribed
+
+	client := example.NewClient(tp)
+	if _, err := client.Sum(context.Background(), &example.Req{Left: 1, Right: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := <-values; v != "value" {
+		t.Errorf("expected handler context to carry the values of the serve context, got %v", v)
+	}
+
+	cancel()
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// The server is shut down once its context is done.
+	if err := srv.Serve(context.Background()); err != nil {
+		t.Errorf("expected serve to return without error, got %v", err)
+	}
+
+	select {
+	case sub := <-tp.subscribed:
+		t.Errorf("expected no subscriptions, got %s", sub)
+	default:
+	}
+}
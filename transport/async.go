@@ -0,0 +1,378 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/go-nats"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Future is the pending reply of a request sent by RequestAsync.
+type Future interface {
+	// Done returns a channel that is closed once the request completes.
+	Done() <-chan struct{}
+
+	// Wait waits for the request to complete and returns the reply, which
+	// is decoded into the reply message passed to RequestAsync, or the
+	// error. If the context is done first, its error is returned but the
+	// request is not cancelled.
+	Wait(ctx context.Context) (*Message, error)
+}
+
+// future is the Future of a request.
+type future struct {
+	// ctx is the context of the request.
+	ctx context.Context
+	rep proto.Message
+
+	// complete is called once with the result of the request.
+	complete func(rm *Message, err error)
+
+	once sync.Once
+	done chan struct{}
+	rm   *Message
+	err  error
+
+	// The reply is decoded by the first call to Wait.
+	decode    sync.Once
+	decodeErr error
+}
+
+func (f *future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *future) Wait(ctx context.Context) (*Message, error) {
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, statusError(ctx.Err())
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	f.decode.Do(func() {
+		if f.rep != nil {
+			f.decodeErr = f.rm.Decode(f.rep)
+		}
+	})
+
+	if f.decodeErr != nil {
+		return nil, f.decodeErr
+	}
+
+	return f.rm, nil
+}
+
+// resolve completes the request unless it already completed. Errors are
+// stored as status errors.
+func (f *future) resolve(rm *Message, err error) {
+	f.once.Do(func() {
+		f.rm = rm
+		f.err = statusError(err)
+		f.complete(rm, f.err)
+		close(f.done)
+	})
+}
+
+// replyMux routes the replies received on the shared inbox of a transport
+// to the futures of the requests by message id.
+type replyMux struct {
+	inbox string
+
+	mux     sync.Mutex
+	pending map[string]pendingReply
+	err     error
+}
+
+// pendingReply is a request waiting for its reply.
+type pendingReply struct {
+	f *future
+
+	// stop stops the future from being resolved once its context is done.
+	stop func() bool
+}
+
+// add registers the future of a request. Unless the reply is received
+// first, the future is resolved with the error of the context once it is
+// done, without a goroutine waiting for it, which requires Go 1.21 for
+// context.AfterFunc. An error is returned if the transport is closed.
+func (r *replyMux) add(ctx context.Context, id string, f *future) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	// Called in its own goroutine, so the lock is not held.
+	stop := context.AfterFunc(ctx, func() {
+		r.mux.Lock()
+		if p, ok := r.pending[id]; ok && p.f == f {
+			delete(r.pending, id)
+		}
+		r.mux.Unlock()
+
+		f.resolve(nil, ctx.Err())
+	})
+
+	r.pending[id] = pendingReply{f: f, stop: stop}
+
+	return nil
+}
+
+// remove unregisters the future of a request and returns it, if it is
+// still pending.
+func (r *replyMux) remove(id string) *future {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	p, ok := r.pending[id]
+	if !ok {
+		return nil
+	}
+
+	delete(r.pending, id)
+	p.stop()
+
+	return p.f
+}
+
+// close resolves the pending futures with the error and fails requests
+// added later.
+func (r *replyMux) close(err error) {
+	r.mux.Lock()
+	pending := r.pending
+	r.pending = make(map[string]pendingReply)
+	r.err = err
+	r.mux.Unlock()
+
+	for _, p := range pending {
+		p.stop()
+		p.f.resolve(nil, err)
+	}
+}
+
+// replies returns the reply mux of the transport, subscribing to the shared
+// inbox on first use.
+func (c *transport) replies() (*replyMux, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.replyMux != nil {
+		return c.replyMux, nil
+	}
+
+	r := &replyMux{
+		inbox:   nats.NewInbox(),
+		pending: make(map[string]pendingReply),
+	}
+
+	if _, err := c.conn.Subscribe(r.inbox, func(nmsg *nats.Msg) {
+		c.routeReply(r, nmsg)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Requests still pending fail once the transport is closed.
+	context.AfterFunc(c.ctx, func() {
+		r.close(nats.ErrConnectionClosed)
+	})
+
+	c.replyMux = r
+
+	return r, nil
+}
+
+// routeReply resolves the future of the request the reply is caused by.
+// Replies to requests that already completed, such as by timing out, are
+// dropped.
+func (c *transport) routeReply(r *replyMux, nmsg *nats.Msg) {
+	rm, err := c.unwrap(nmsg)
+	if err != nil {
+		c.logger.Error("failed to decode reply",
+			zap.String("msg.subject", nmsg.Subject),
+			zap.Error(err),
+		)
+		return
+	}
+
+	f := r.remove(rm.Cause)
+	if f == nil {
+		return
+	}
+
+	// The chunks are fetched without holding up other replies.
+	if rm.Chunks > 0 {
+		go func() {
+			full, err := c.reassemble(f.ctx, rm)
+			if err != nil {
+				f.resolve(nil, err)
+				return
+			}
+
+			f.resolve(full, replyError(full))
+		}()
+		return
+	}
+
+	f.resolve(rm, replyError(rm))
+}
+
+// RequestAsync sends a request without waiting for the reply. The reply is
+// received on an inbox shared by all asynchronous requests of the
+// transport, so many requests can be in flight at little cost. Errors,
+// including those of sending the request, are returned by the Wait method
+// of the future. Retry policies and hedging do not apply. Client
+// interceptors observe the reply like those of Request. Since the chain
+// returns only once the reply is received, a request with interceptors
+// holds a goroutine until it completes.
+func (c *transport) RequestAsync(ctx context.Context, sub string, req proto.Message, rep proto.Message, opts ...RequestOption) Future {
+	reqOpts := &RequestOptions{
+		Timeout:    DefaultRequestTimeout,
+		Codec:      c.opts.Codec,
+		Compressor: c.opts.Compressor,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(reqOpts)
+	}
+
+	var cancel context.CancelFunc
+	if reqOpts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, reqOpts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	f := &future{
+		ctx:  ctx,
+		rep:  rep,
+		done: make(chan struct{}),
+	}
+
+	failed := func(err error) Future {
+		f.complete = func(*Message, error) {}
+		f.resolve(nil, err)
+		cancel()
+		return f
+	}
+
+	r, err := c.replies()
+	if err != nil {
+		return failed(err)
+	}
+
+	m, err := c.wrap(req, reqOpts.Codec)
+	if err != nil {
+		return failed(err)
+	}
+
+	if err := compress(m, reqOpts.Compressor, c.opts.CompressionThreshold); err != nil {
+		return failed(err)
+	}
+
+	m.Subject = sub
+	m.Cause = reqOpts.Cause
+	m.Metadata = reqOpts.Metadata
+	m.Reply = r.inbox
+
+	if dl, ok := ctx.Deadline(); ok {
+		m.Deadline = uint64(dl.UnixNano())
+	}
+
+	ctx, span := c.startClientSpan(ctx, m, trace.SpanKindClient)
+	f.ctx = ctx
+
+	start := time.Now()
+	c.opts.Metrics.ClientStarted(KindRequest, sub, len(m.Payload))
+
+	// Whether the request was let through by the circuit breaker, in which
+	// case the result is recorded in the generation of the circuit.
+	var (
+		allowed bool
+		gen     uint64
+	)
+
+	f.complete = func(rm *Message, err error) {
+		cancel()
+		endSpan(span, err)
+
+		if allowed {
+			c.breakers.done(sub, gen, err)
+		}
+
+		var size int
+		if rm != nil {
+			size = len(rm.Payload)
+		}
+
+		c.opts.Metrics.ClientHandled(KindRequest, sub, errorStatus(err).Code(), time.Since(start), size)
+	}
+
+	// Fail fast while the circuit of the subject is open.
+	if c.breakers != nil {
+		if gen, err = c.breakers.allow(sub); err != nil {
+			f.resolve(nil, err)
+			return f
+		}
+		allowed = true
+	}
+
+	interceptors := joinClientInterceptors(c.opts.ClientInterceptors, reqOpts.Interceptors)
+
+	// Without interceptors, the future is resolved by the reply mux.
+	if len(interceptors) == 0 {
+		if err := c.sendAsync(ctx, r, m, f); err != nil {
+			f.resolve(nil, err)
+		}
+		return f
+	}
+
+	// The chain waits for the reply, which resolves a future of its own,
+	// and its result resolves the future of the caller.
+	invoke := chainInvoker(interceptors, func(ctx context.Context, m *Message) (*Message, error) {
+		p := &future{
+			ctx:      ctx,
+			complete: func(*Message, error) {},
+			done:     make(chan struct{}),
+		}
+
+		if err := c.sendAsync(ctx, r, m, p); err != nil {
+			return nil, err
+		}
+
+		<-p.done
+		return p.rm, p.err
+	})
+
+	// The goroutine blocks in the invoker until the reply is received.
+	go func() {
+		f.resolve(invoke(ctx, m))
+	}()
+
+	return f
+}
+
+// sendAsync sends the request with its reply routed to the future by the
+// reply mux.
+func (c *transport) sendAsync(ctx context.Context, r *replyMux, m *Message, f *future) error {
+	// Registered before the request is sent so the reply is not missed.
+	if err := r.add(ctx, m.Id, f); err != nil {
+		return err
+	}
+
+	if _, err := c.publish(ctx, m); err != nil {
+		r.remove(m.Id)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestRequestAsync(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	// Replies are sent out of order by concurrent workers.
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		return &Message{Subject: msg.Metadata["n"]}, nil
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr, SubscribeConcurrency(8)); err != nil {
+		t.Fatal(err)
+	}
+
+	futures := make([]Future, 100)
+	replies := make([]Message, 100)
+
+	for i := range futures {
+		md := Metadata{"n": fmt.Sprint(i)}
+		futures[i] = tp.RequestAsync(context.Background(), "_transport", nil, &replies[i], RequestMetadata(md))
+	}
+
+	for i, f := range futures {
+		if _, err := f.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if replies[i].Subject != fmt.Sprint(i) {
+			t.Errorf("expected reply %d, got %s", i, replies[i].Subject)
+		}
+	}
+}
+
+func TestRequestAsyncError(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		return nil, status.Error(codes.NotFound, "missing")
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	f := tp.RequestAsync(context.Background(), "_transport", nil, nil)
+
+	if _, err := f.Wait(context.Background()); status.Code(err) != codes.NotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestRequestAsyncTimeout(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	f := tp.RequestAsync(context.Background(), "_transport", nil, nil, RequestTimeout(20*time.Millisecond))
+
+	// Waiting is bound by the context of Wait.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := f.Wait(ctx); status.Code(err) != codes.Canceled {
+		t.Errorf("expected canceled, got %v", err)
+	}
+
+	select {
+	case <-f.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to time out")
+	}
+
+	if _, err := f.Wait(context.Background()); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRequestAsyncChunking(t *testing.T) {
+	b := NewMemoryBroker()
+	b.MaxPayload = 1024
+
+	tp := b.Connect()
+	defer tp.Close()
+
+	_, err := tp.Subscribe("_transport", func(ctx context.Context, msg *Message) (proto.Message, error) {
+		return &Message{Id: strings.Repeat("x", 4096)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep Message
+	if _, err := tp.RequestAsync(context.Background(), "_transport", nil, &rep).Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rep.Id) != 4096 {
+		t.Errorf("expected reassembled reply, got %d bytes", len(rep.Id))
+	}
+}
+
+func TestRequestAsyncInterceptor(t *testing.T) {
+	type observed struct {
+		subject string
+		code    codes.Code
+	}
+
+	seen := make(chan observed, 2)
+
+	// Interceptors see the reply and its status.
+	observe := func(ctx context.Context, msg *Message, invoker Invoker) (*Message, error) {
+		rm, err := invoker(ctx, msg)
+
+		o := observed{code: status.Code(err)}
+		if err == nil {
+			var v Message
+			if derr := rm.Decode(&v); derr != nil {
+				t.Error(derr)
+			}
+			o.subject = v.Subject
+		}
+		seen <- o
+
+		return rm, err
+	}
+
+	tp := NewMemory(WithClientInterceptors(observe))
+	defer tp.Close()
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		if msg.Cause == "fail" {
+			return nil, status.Error(codes.NotFound, "missing")
+		}
+		return &Message{Subject: "reply"}, nil
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	var rep Message
+	if _, err := tp.RequestAsync(context.Background(), "_transport", nil, &rep).Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if o := <-seen; o.subject != "reply" || o.code != codes.OK {
+		t.Errorf("expected interceptor to observe the reply, got %+v", o)
+	}
+
+	_, err := tp.RequestAsync(context.Background(), "_transport", nil, nil, RequestCause("fail")).Wait(context.Background())
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	if o := <-seen; o.code != codes.NotFound {
+		t.Errorf("expected interceptor to observe the status, got %+v", o)
+	}
+}
+
+func TestRequestAsyncClose(t *testing.T) {
+	tp := NewMemory()
+
+	// Without a timeout, the request is pending until the transport closes.
+	f := tp.RequestAsync(context.Background(), "_transport", nil, nil, RequestTimeout(0))
+
+	tp.Close()
+
+	select {
+	case <-f.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to fail once the transport is closed")
+	}
+
+	if _, err := f.Wait(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.uber.org/zap"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through while counting failures.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen fails requests without sending them until the cool-down
+	// has passed.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a number of trial requests through, closing the
+	// circuit if they succeed or opening it again if one fails.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var (
+	// DefaultCircuitFailureCodes are the status codes counted as failures
+	// if a circuit breaker does not set any.
+	DefaultCircuitFailureCodes = []codes.Code{
+		codes.Unavailable,
+		codes.DeadlineExceeded,
+		codes.Internal,
+	}
+
+	// DefaultCircuitBreaker is a circuit breaker suitable for most services.
+	DefaultCircuitBreaker = CircuitBreaker{
+		FailureRatio:     0.5,
+		MinRequests:      10,
+		Window:           10 * time.Second,
+		CoolDown:         5 * time.Second,
+		HalfOpenRequests: 1,
+	}
+)
+
+// CircuitBreaker describes when requests to a subject fail fast. The state
+// is tracked per subject. Once the ratio of failed requests reaches
+// FailureRatio, the circuit opens and requests fail with codes.Unavailable
+// without being sent. After the cool-down, trial requests are let through to
+// decide whether to close the circuit again.
+type CircuitBreaker struct {
+	// FailureRatio is the ratio of failed requests within the window at
+	// which the circuit opens.
+	FailureRatio float64
+
+	// MinRequests is the number of requests within the window before the
+	// ratio is considered.
+	MinRequests int
+
+	// Window is how long requests are counted before the counts are reset.
+	// Zero never resets them.
+	Window time.Duration
+
+	// CoolDown is how long the circuit stays open.
+	CoolDown time.Duration
+
+	// HalfOpenRequests is the number of trial requests that must succeed to
+	// close the circuit. Defaults to one.
+	HalfOpenRequests int
+
+	// FailureCodes are the status codes counted as failures. Defaults to
+	// DefaultCircuitFailureCodes. Requests cancelled by the caller are not
+	// counted.
+	FailureCodes []codes.Code
+
+	// OnStateChange is called when the state of the circuit of a subject
+	// changes. Changes are also logged by the transport.
+	OnStateChange func(subject string, from, to CircuitState)
+}
+
+// WithCircuitBreaker enables the circuit breaker for requests. Circuits are
+// tracked per subject.
+func WithCircuitBreaker(b CircuitBreaker) Option {
+	return func(o *Options) {
+		o.CircuitBreaker = &b
+	}
+}
+
+// failure returns true if the error has a status code counted as a failure.
+func (b *CircuitBreaker) failure(err error) bool {
+	failures := b.FailureCodes
+	if failures == nil {
+		failures = DefaultCircuitFailureCodes
+	}
+
+	code := errorStatus(err).Code()
+
+	for _, c := range failures {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxCircuits is the number of circuits above which unused closed circuits
+// are removed, see sweep.
+const maxCircuits = 10000
+
+// circuit is the state of the circuit breaker of a subject.
+type circuit struct {
+	state CircuitState
+
+	// Counts of the current window when closed.
+	start    time.Time
+	requests int
+	failures int
+
+	// opened is when the circuit was last opened.
+	opened time.Time
+
+	// Trial requests in flight and succeeded when half-open.
+	trials    int
+	successes int
+
+	// generation changes when the state changes or the counts are reset,
+	// so the results of requests allowed before are ignored.
+	generation uint64
+}
+
+// breakers holds the circuits of a circuit breaker by subject.
+type breakers struct {
+	opts   *CircuitBreaker
+	logger func() *zap.Logger
+
+	mux      sync.Mutex
+	circuits map[string]*circuit
+
+	// generation is the last generation of any circuit, so generations are
+	// not reused by a circuit created again after being removed.
+	generation uint64
+}
+
+func newBreakers(opts *CircuitBreaker, logger func() *zap.Logger) *breakers {
+	return &breakers{
+		opts:     opts,
+		logger:   logger,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// allow returns an error if the circuit of the subject is open. Otherwise
+// the result of the request must be passed to done with the returned
+// generation.
+func (b *breakers) allow(subject string) (uint64, error) {
+	now := time.Now()
+
+	b.mux.Lock()
+
+	c, ok := b.circuits[subject]
+	if !ok {
+		if len(b.circuits) >= maxCircuits {
+			b.sweep(now)
+		}
+
+		c = &circuit{start: now}
+		b.advance(c)
+		b.circuits[subject] = c
+	}
+
+	from := c.state
+
+	if c.state == CircuitOpen && now.Sub(c.opened) >= b.opts.CoolDown {
+		c.state = CircuitHalfOpen
+		c.trials = 0
+		c.successes = 0
+		b.advance(c)
+	}
+
+	var err error
+
+	switch c.state {
+	case CircuitClosed:
+		if b.opts.Window > 0 && now.Sub(c.start) >= b.opts.Window {
+			c.start = now
+			c.requests = 0
+			c.failures = 0
+			b.advance(c)
+		}
+
+	case CircuitOpen:
+		err = retryStatus(codes.Unavailable, "circuit breaker is open", c.opened.Add(b.opts.CoolDown).Sub(now)).Err()
+
+	case CircuitHalfOpen:
+		if c.trials < b.halfOpenRequests() {
+			c.trials++
+		} else {
+			err = status.Error(codes.Unavailable, "circuit breaker is half-open")
+		}
+	}
+
+	to, gen := c.state, c.generation
+	b.mux.Unlock()
+
+	b.changed(subject, from, to)
+
+	return gen, err
+}
+
+// done records the result of a request allowed by allow in the given
+// generation of the circuit.
+func (b *breakers) done(subject string, gen uint64, err error) {
+	now := time.Now()
+
+	b.mux.Lock()
+
+	c, ok := b.circuits[subject]
+	if !ok {
+		b.mux.Unlock()
+		return
+	}
+
+	from := c.state
+
+	// The request was allowed before the state changed, such as a slow
+	// request sent while closed completing once half-open, or before the
+	// circuit was removed, so it says nothing about the current state.
+	if gen != c.generation {
+		b.mux.Unlock()
+		return
+	}
+
+	// Requests cancelled by the caller say nothing about the subscriber.
+	cancelled := errorStatus(err).Code() == codes.Canceled
+	failed := err != nil && b.opts.failure(err)
+
+	switch c.state {
+	case CircuitClosed:
+		if cancelled {
+			break
+		}
+
+		c.requests++
+		if failed {
+			c.failures++
+		}
+
+		if c.requests >= b.opts.MinRequests && float64(c.failures) >= b.opts.FailureRatio*float64(c.requests) {
+			c.state = CircuitOpen
+			c.opened = now
+			b.advance(c)
+		}
+
+	case CircuitHalfOpen:
+		switch {
+		case cancelled:
+			c.trials--
+
+		case failed:
+			c.state = CircuitOpen
+			c.opened = now
+			b.advance(c)
+
+		default:
+			c.successes++
+			if c.successes >= b.halfOpenRequests() {
+				c.state = CircuitClosed
+				c.start = now
+				c.requests = 0
+				c.failures = 0
+				b.advance(c)
+			}
+		}
+	}
+
+	to := c.state
+	b.mux.Unlock()
+
+	b.changed(subject, from, to)
+}
+
+// advance starts a new generation of the circuit.
+func (b *breakers) advance(c *circuit) {
+	b.generation++
+	c.generation = b.generation
+}
+
+// sweep removes closed circuits whose window has passed, which behave the
+// same as new ones, and those without failures, which would only open
+// later than a new one.
+func (b *breakers) sweep(now time.Time) {
+	for subject, c := range b.circuits {
+		if c.state != CircuitClosed {
+			continue
+		}
+
+		expired := b.opts.Window > 0 && now.Sub(c.start) >= b.opts.Window
+		if expired || c.failures == 0 {
+			delete(b.circuits, subject)
+		}
+	}
+}
+
+func (b *breakers) halfOpenRequests() int {
+	if b.opts.HalfOpenRequests < 1 {
+		return 1
+	}
+	return b.opts.HalfOpenRequests
+}
+
+// changed logs the state change of a circuit, if any, and calls the hook.
+func (b *breakers) changed(subject string, from, to CircuitState) {
+	if from == to {
+		return
+	}
+
+	b.logger().Info("circuit breaker state changed",
+		zap.String("msg.subject", subject),
+		zap.Stringer("circuit.from", from),
+		zap.Stringer("circuit.to", to),
+	)
+
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(subject, from, to)
+	}
+}
+
+// invokeCircuit invokes the request unless the circuit breaker is enabled
+// and the circuit of the subject is open. The result is recorded in the
+// circuit.
+func (c *transport) invokeCircuit(ctx context.Context, p *RetryPolicy, m *Message, invoke Invoker) (*Message, error) {
+	if c.breakers == nil {
+		return c.invokeRequest(ctx, p, m, invoke)
+	}
+
+	sub := m.Subject
+
+	gen, err := c.breakers.allow(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	rm, err := c.invokeRequest(ctx, p, m, invoke)
+	c.breakers.done(sub, gen, err)
+
+	return rm, err
+}
@@ -0,0 +1,254 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []CircuitState
+
+	b := newBreakers(&CircuitBreaker{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		CoolDown:     100 * time.Millisecond,
+		OnStateChange: func(subject string, from, to CircuitState) {
+			changes = append(changes, to)
+		},
+	}, zap.NewNop)
+
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	// Caller errors and cancellations are not failures.
+	for _, err := range []error{
+		nil,
+		status.Error(codes.InvalidArgument, "bad"),
+		context.Canceled,
+		unavailable,
+	} {
+		gen, aerr := b.allow("a")
+		if aerr != nil {
+			t.Fatal(aerr)
+		}
+		b.done("a", gen, err)
+	}
+
+	if len(changes) != 0 {
+		t.Fatalf("expected the circuit to stay closed, got %v", changes)
+	}
+
+	gen, err := b.allow("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.done("a", gen, unavailable)
+
+	_, err = b.allow("a")
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected open circuit to fail fast, got %v", err)
+	}
+
+	// Circuits are tracked per subject.
+	gen, err = b.allow("b")
+	if err != nil {
+		t.Errorf("expected other subject to be closed, got %v", err)
+	}
+	b.done("b", gen, nil)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// One trial request is let through when half-open.
+	gen, err = b.allow("a")
+	if err != nil {
+		t.Fatalf("expected trial request, got %v", err)
+	}
+	if _, err := b.allow("a"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a single trial request, got %v", err)
+	}
+
+	b.done("a", gen, nil)
+
+	gen, err = b.allow("a")
+	if err != nil {
+		t.Errorf("expected closed circuit after successful trial, got %v", err)
+	}
+	b.done("a", gen, nil)
+
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+	for i, s := range expected {
+		if changes[i] != s {
+			t.Errorf("expected changes %v, got %v", expected, changes)
+			break
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	b := newBreakers(&CircuitBreaker{
+		FailureRatio: 1,
+		MinRequests:  1,
+		CoolDown:     100 * time.Millisecond,
+	}, zap.NewNop)
+
+	gen, _ := b.allow("a")
+	b.done("a", gen, status.Error(codes.DeadlineExceeded, "slow"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	gen, err := b.allow("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.done("a", gen, errors.New("handler failed"))
+
+	// Unknown is not a failure by default.
+	gen, err = b.allow("a")
+	if err != nil {
+		t.Fatalf("expected closed circuit, got %v", err)
+	}
+	b.done("a", gen, status.Error(codes.Internal, "broken"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	gen, _ = b.allow("a")
+	b.done("a", gen, status.Error(codes.Unavailable, "down"))
+
+	if _, err := b.allow("a"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected failed trial to open the circuit, got %v", err)
+	}
+}
+
+func TestCircuitBreakerGeneration(t *testing.T) {
+	var changes []CircuitState
+
+	b := newBreakers(&CircuitBreaker{
+		FailureRatio: 1,
+		MinRequests:  1,
+		CoolDown:     100 * time.Millisecond,
+		OnStateChange: func(subject string, from, to CircuitState) {
+			changes = append(changes, to)
+		},
+	}, zap.NewNop)
+
+	// A slow request is sent while the circuit is closed.
+	slow, err := b.allow("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, _ := b.allow("a")
+	b.done("a", gen, status.Error(codes.Unavailable, "down"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	trial, err := b.allow("a")
+	if err != nil {
+		t.Fatalf("expected trial request, got %v", err)
+	}
+
+	// The slow request fails once the circuit is half-open, which does not
+	// open it again.
+	b.done("a", slow, status.Error(codes.DeadlineExceeded, "slow"))
+
+	if changes[len(changes)-1] != CircuitHalfOpen {
+		t.Fatalf("expected the circuit to stay half-open, got %v", changes)
+	}
+
+	b.done("a", trial, nil)
+
+	if _, err := b.allow("a"); err != nil {
+		t.Errorf("expected closed circuit after successful trial, got %v", err)
+	}
+
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestCircuitBreakerSweep(t *testing.T) {
+	b := newBreakers(&CircuitBreaker{
+		FailureRatio: 1,
+		MinRequests:  2,
+		Window:       time.Minute,
+		CoolDown:     time.Minute,
+	}, zap.NewNop)
+
+	failed := status.Error(codes.Unavailable, "down")
+
+	// A circuit with a failure in its window is kept.
+	gen, _ := b.allow("failing")
+	b.done("failing", gen, failed)
+
+	pending, _ := b.allow("pending")
+
+	for i := 0; i < maxCircuits; i++ {
+		subject := fmt.Sprint("entity.", i)
+
+		gen, err := b.allow(subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.done(subject, gen, nil)
+	}
+
+	if n := len(b.circuits); n > maxCircuits {
+		t.Errorf("expected at most %d circuits, got %d", maxCircuits, n)
+	}
+
+	if _, ok := b.circuits["failing"]; !ok {
+		t.Error("expected the circuit with a failure to be kept")
+	}
+
+	// The result of a request of a removed circuit is ignored.
+	b.done("pending", pending, failed)
+
+	gen, _ = b.allow("failing")
+	b.done("failing", gen, failed)
+
+	if _, err := b.allow("failing"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the kept circuit to open, got %v", err)
+	}
+}
+
+func TestRequestCircuitBreaker(t *testing.T) {
+	tp := NewMemory(WithCircuitBreaker(CircuitBreaker{
+		FailureRatio: 1,
+		MinRequests:  2,
+		CoolDown:     time.Minute,
+	}))
+	defer tp.Close()
+
+	var calls int
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		calls++
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := tp.Request(context.Background(), "_transport", nil, nil)
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("expected unavailable, got %v", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("expected the open circuit to fail fast, got %d calls", calls)
+	}
+}
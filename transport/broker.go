@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// brokerSubscription is a subscription of a broker.
+type brokerSubscription interface {
+	Unsubscribe() error
+
+	// Drain removes the subscription from the broker and returns once the
+	// messages it already received are passed to the callback, or the
+	// context is done.
+	Drain(ctx context.Context) error
+}
+
+// broker is the subset of a NATS connection used by the transport. It is
+// implemented by NATS connections and by MemoryBroker connections.
+type broker interface {
+	Publish(subject string, data []byte) error
+	PublishRequest(subject, reply string, data []byte) error
+	RequestWithContext(ctx context.Context, subject string, data []byte) (*nats.Msg, error)
+	Subscribe(subject string, cb nats.MsgHandler) (brokerSubscription, error)
+	QueueSubscribe(subject, queue string, cb nats.MsgHandler) (brokerSubscription, error)
+	MaxPayload() int64
+	Close()
+}
+
+// natsBroker adapts a NATS connection to the broker interface.
+type natsBroker struct {
+	*nats.Conn
+}
+
+func (b natsBroker) Subscribe(subject string, cb nats.MsgHandler) (brokerSubscription, error) {
+	s, err := b.Conn.Subscribe(subject, cb)
+	if err != nil {
+		return nil, err
+	}
+	return natsSubscription{s}, nil
+}
+
+func (b natsBroker) QueueSubscribe(subject, queue string, cb nats.MsgHandler) (brokerSubscription, error) {
+	s, err := b.Conn.QueueSubscribe(subject, queue, cb)
+	if err != nil {
+		return nil, err
+	}
+	return natsSubscription{s}, nil
+}
+
+// drainInterval is how often a draining NATS subscription is checked, since
+// NATS does not signal when draining is complete.
+const drainInterval = 10 * time.Millisecond
+
+// natsSubscription adapts a NATS subscription to the brokerSubscription
+// interface.
+type natsSubscription struct {
+	*nats.Subscription
+}
+
+func (s natsSubscription) Drain(ctx context.Context) error {
+	if err := s.Subscription.Drain(); err != nil {
+		return err
+	}
+
+	t := time.NewTicker(drainInterval)
+	defer t.Stop()
+
+	// The subscription becomes invalid once its pending messages are
+	// delivered.
+	for s.IsValid() {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
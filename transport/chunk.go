@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nuid"
+	"go.uber.org/zap"
+)
+
+var (
+	// DefaultMaxMessageSize is the default maximum size in bytes of a message
+	// that is chunked and reassembled.
+	DefaultMaxMessageSize = 64 * 1024 * 1024
+
+	// DefaultChunkTimeout is how long the chunks of a message are served and
+	// how long a receiver waits for all chunks to be fetched.
+	DefaultChunkTimeout = 30 * time.Second
+
+	chunkPrefix = "_CHUNKS."
+)
+
+// errMessageTooLarge returns the error for a message exceeding the maximum size.
+func errMessageTooLarge(size uint64, max int) error {
+	return status.Errorf(codes.ResourceExhausted, "message size %d exceeds maximum of %d bytes", size, max)
+}
+
+// encode marshals the message for sending. If it exceeds the max payload of
+// the connection, the message is split into chunks served on a dedicated
+// subject until ctx is done or the chunk timeout passes. A header referencing
+// the chunks is returned in place of the message. It carries the metadata
+// and trace context, so a receiver can trace and admit the message before
+// fetching the chunks.
+func (c *transport) encode(ctx context.Context, m *Message) ([]byte, error) {
+	mb, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	max := int(c.conn.MaxPayload())
+	if len(mb) <= max {
+		return mb, nil
+	}
+
+	if len(mb) > c.opts.MaxMessageSize {
+		return nil, errMessageTooLarge(uint64(len(mb)), c.opts.MaxMessageSize)
+	}
+
+	chunks := (len(mb) + max - 1) / max
+	subject := chunkPrefix + nuid.Next()
+
+	if err := c.serveChunks(ctx, subject, mb, max); err != nil {
+		return nil, err
+	}
+
+	hdr := Message{
+		Id:           m.Id,
+		Timestamp:    m.Timestamp,
+		Cause:        m.Cause,
+		Subject:      m.Subject,
+		Deadline:     m.Deadline,
+		Metadata:     m.Metadata,
+		TraceContext: m.TraceContext,
+		Frame:        m.Frame,
+		Chunks:       uint32(chunks),
+		ChunkSubject: subject,
+		Size:         uint64(len(mb)),
+	}
+
+	return proto.Marshal(&hdr)
+}
+
+// serveChunks replies to chunk requests on the subject. The request data is
+// the index of the chunk.
+func (c *transport) serveChunks(ctx context.Context, subject string, mb []byte, size int) error {
+	sub, err := c.conn.Subscribe(subject, func(nmsg *nats.Msg) {
+		i, err := strconv.Atoi(string(nmsg.Data))
+		if err != nil || i < 0 || i*size >= len(mb) {
+			c.logger.Warn("invalid chunk request",
+				zap.String("msg.subject", subject),
+				zap.String("chunk.index", string(nmsg.Data)),
+			)
+			return
+		}
+
+		end := (i + 1) * size
+		if end > len(mb) {
+			end = len(mb)
+		}
+
+		if err := c.conn.Publish(nmsg.Reply, mb[i*size:end]); err != nil {
+			c.logger.Error("failed to publish chunk",
+				zap.String("msg.subject", subject),
+				zap.Error(err),
+			)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.ChunkTimeout)
+
+	go func() {
+		<-ctx.Done()
+		cancel()
+		sub.Unsubscribe()
+	}()
+
+	return nil
+}
+
+// reassemble fetches the chunks referenced by the header and returns the
+// full message. The transport-level fields of the header are preserved.
+func (c *transport) reassemble(ctx context.Context, hdr *Message) (*Message, error) {
+	if hdr.Size > uint64(c.opts.MaxMessageSize) {
+		return nil, errMessageTooLarge(hdr.Size, c.opts.MaxMessageSize)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.ChunkTimeout)
+	defer cancel()
+
+	mb := make([]byte, 0, hdr.Size)
+
+	for i := 0; i < int(hdr.Chunks); i++ {
+		nm, err := c.conn.RequestWithContext(ctx, hdr.ChunkSubject, []byte(strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+
+		if uint64(len(mb)+len(nm.Data)) > hdr.Size {
+			return nil, status.Errorf(codes.DataLoss, "chunks of message %s exceed the declared size", hdr.Id)
+		}
+
+		mb = append(mb, nm.Data...)
+	}
+
+	if uint64(len(mb)) != hdr.Size {
+		return nil, status.Errorf(codes.DataLoss, "chunks of message %s are incomplete", hdr.Id)
+	}
+
+	var msg Message
+	if err := proto.Unmarshal(mb, &msg); err != nil {
+		return nil, err
+	}
+
+	msg.Subject = hdr.Subject
+	msg.Reply = hdr.Reply
+	msg.Queue = hdr.Queue
+
+	return &msg, nil
+}
@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Codec encodes and decodes message payloads. The content type of the codec
+// is recorded in the message envelope so subscribers can decode payloads
+// from producers using different codecs.
+type Codec interface {
+	// ContentType identifies the codec.
+	ContentType() string
+
+	// Marshal encodes the message.
+	Marshal(proto.Message) ([]byte, error)
+
+	// Unmarshal decodes the data into the message.
+	Unmarshal([]byte, proto.Message) error
+}
+
+var (
+	// ProtoCodec encodes payloads using the protobuf binary format. This is
+	// the default codec.
+	ProtoCodec Codec = protoCodec{}
+
+	// JSONCodec encodes payloads using the protobuf JSON mapping.
+	JSONCodec Codec = jsonCodec{}
+
+	// RawCodec passes payloads through as is. Messages must be of type *RawMessage.
+	RawCodec Codec = rawCodec{}
+
+	codecs   = map[string]Codec{}
+	codecsMu sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(ProtoCodec)
+	RegisterCodec(JSONCodec)
+	RegisterCodec(RawCodec)
+}
+
+// RegisterCodec makes a codec available for decoding payloads with its content
+// type. Registering a codec with the same content type replaces the existing one.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	codecs[c.ContentType()] = c
+	codecsMu.Unlock()
+}
+
+// codecFor returns the codec registered for the content type. An empty
+// content type is treated as protobuf for messages from older transports.
+func codecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return ProtoCodec, nil
+	}
+
+	codecsMu.RLock()
+	c, ok := codecs[contentType]
+	codecsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type %q", contentType)
+	}
+
+	return c, nil
+}
+
+type protoCodec struct{}
+
+func (protoCodec) ContentType() string {
+	return "application/protobuf"
+}
+
+func (protoCodec) Marshal(pb proto.Message) ([]byte, error) {
+	return proto.Marshal(pb)
+}
+
+func (protoCodec) Unmarshal(b []byte, pb proto.Message) error {
+	return proto.Unmarshal(b, pb)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(pb proto.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, pb); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (jsonCodec) Unmarshal(b []byte, pb proto.Message) error {
+	return jsonpb.Unmarshal(bytes.NewReader(b), pb)
+}
+
+// RawMessage is a payload that is already encoded. It is passed through
+// as is by RawCodec.
+type RawMessage []byte
+
+func (m *RawMessage) Reset()         { *m = nil }
+func (m *RawMessage) String() string { return string(*m) }
+func (*RawMessage) ProtoMessage()    {}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Marshal(pb proto.Message) ([]byte, error) {
+	m, ok := pb.(*RawMessage)
+	if !ok {
+		return nil, fmt.Errorf("raw codec: expected *RawMessage, got %T", pb)
+	}
+	return *m, nil
+}
+
+func (rawCodec) Unmarshal(b []byte, pb proto.Message) error {
+	m, ok := pb.(*RawMessage)
+	if !ok {
+		return fmt.Errorf("raw codec: expected *RawMessage, got %T", pb)
+	}
+	*m = append((*m)[:0], b...)
+	return nil
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, c := range []Codec{ProtoCodec, JSONCodec} {
+		in := &Message{
+			Id:       "abc",
+			Metadata: map[string]string{"tenant": "chop"},
+		}
+
+		b, err := c.Marshal(in)
+		if err != nil {
+			t.Fatalf("%s: %s", c.ContentType(), err)
+		}
+
+		msg := &Message{
+			Payload:     b,
+			ContentType: c.ContentType(),
+		}
+
+		var out Message
+		if err := msg.Decode(&out); err != nil {
+			t.Fatalf("%s: %s", c.ContentType(), err)
+		}
+
+		if out.Id != in.Id || out.Metadata["tenant"] != "chop" {
+			t.Errorf("%s: expected %s, got %s", c.ContentType(), in, &out)
+		}
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	in := RawMessage("raw bytes")
+
+	b, err := RawCodec.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{
+		Payload:     b,
+		ContentType: RawCodec.ContentType(),
+	}
+
+	var out RawMessage
+	if err := msg.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "raw bytes" {
+		t.Errorf("expected raw bytes, got %q", out)
+	}
+
+	if _, err := RawCodec.Marshal(&Message{}); err == nil {
+		t.Error("expected error for non-raw message")
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	c, err := codecFor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != ProtoCodec {
+		t.Errorf("expected protobuf codec for empty content type, got %s", c.ContentType())
+	}
+
+	if _, err := codecFor("application/x-unknown"); err == nil {
+		t.Error("expected error for unknown content type")
+	}
+}
@@ -0,0 +1,226 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// DefaultCompressionThreshold is the payload size in bytes below which
+	// compression is skipped.
+	DefaultCompressionThreshold = 1024
+
+	// MaxDecompressedSize is the maximum size in bytes a payload is
+	// decompressed to by the built-in compressors, so a small payload
+	// cannot expand without bound. Larger payloads fail to decode with a
+	// ResourceExhausted status. It should be set before messages are
+	// decoded.
+	MaxDecompressedSize = 64 * 1024 * 1024
+)
+
+// errDecompressedTooLarge returns the error for a payload decompressing to
+// more than MaxDecompressedSize bytes.
+func errDecompressedTooLarge() error {
+	return status.Errorf(codes.ResourceExhausted, "decompressed payload exceeds maximum of %d bytes", MaxDecompressedSize)
+}
+
+// Compressor compresses and decompresses encoded message payloads. The name
+// of the compressor is recorded in the message envelope so receivers
+// decompress payloads transparently.
+type Compressor interface {
+	// Name identifies the compressor.
+	Name() string
+
+	// Compress compresses the data.
+	Compress([]byte) ([]byte, error)
+
+	// Decompress decompresses the data.
+	Decompress([]byte) ([]byte, error)
+}
+
+var (
+	// GzipCompressor compresses payloads using gzip.
+	GzipCompressor Compressor = gzipCompressor{}
+
+	// SnappyCompressor compresses payloads using the snappy block format.
+	SnappyCompressor Compressor = snappyCompressor{}
+
+	// ZstdCompressor compresses payloads using zstd.
+	ZstdCompressor Compressor = &zstdCompressor{}
+
+	compressors   = map[string]Compressor{}
+	compressorsMu sync.RWMutex
+)
+
+func init() {
+	RegisterCompressor(GzipCompressor)
+	RegisterCompressor(SnappyCompressor)
+	RegisterCompressor(ZstdCompressor)
+}
+
+// RegisterCompressor makes a compressor available for decompressing payloads
+// with its name. Registering a compressor with the same name replaces the
+// existing one.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	compressors[c.Name()] = c
+	compressorsMu.Unlock()
+}
+
+// compressorFor returns the compressor registered for the content encoding.
+// An empty encoding means no compression and returns nil.
+func compressorFor(encoding string) (Compressor, error) {
+	if encoding == "" {
+		return nil, nil
+	}
+
+	compressorsMu.RLock()
+	c, ok := compressors[encoding]
+	compressorsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no compressor registered for content encoding %q", encoding)
+	}
+
+	return c, nil
+}
+
+// compress compresses the payload of the message in place if a compressor is
+// given and the payload is at least threshold bytes.
+func compress(m *Message, c Compressor, threshold int) error {
+	if c == nil || len(m.Payload) == 0 || len(m.Payload) < threshold {
+		return nil
+	}
+
+	b, err := c.Compress(m.Payload)
+	if err != nil {
+		return err
+	}
+
+	m.Payload = b
+	m.ContentEncoding = c.Name()
+
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// One more byte than allowed is read to tell if the limit is exceeded.
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out) > MaxDecompressedSize {
+		return nil, errDecompressedTooLarge()
+	}
+
+	return out, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return "snappy"
+}
+
+func (snappyCompressor) Compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func (snappyCompressor) Decompress(b []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > MaxDecompressedSize {
+		return nil, errDecompressedTooLarge()
+	}
+
+	return snappy.Decode(nil, b)
+}
+
+// zstdCompressor lazily initializes a shared encoder and decoder since they
+// are expensive to create and safe for concurrent use. The decoder is
+// limited to the MaxDecompressedSize at the time it is created.
+type zstdCompressor struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		c.enc, c.err = zstd.NewWriter(nil)
+		if c.err != nil {
+			return
+		}
+		c.dec, c.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(MaxDecompressedSize)))
+	})
+
+	return c.err
+}
+
+func (*zstdCompressor) Name() string {
+	return "zstd"
+}
+
+func (c *zstdCompressor) Compress(b []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c.enc.EncodeAll(b, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(b []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	out, err := c.dec.DecodeAll(b, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || len(out) > MaxDecompressedSize {
+		return nil, errDecompressedTooLarge()
+	}
+
+	return out, err
+}
@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCompressors(t *testing.T) {
+	in := &Message{
+		Id: strings.Repeat("abc", 1000),
+	}
+
+	for _, c := range []Compressor{GzipCompressor, SnappyCompressor, ZstdCompressor} {
+		b, err := ProtoCodec.Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := &Message{
+			Payload:     b,
+			ContentType: ProtoCodec.ContentType(),
+		}
+
+		if err := compress(msg, c, DefaultCompressionThreshold); err != nil {
+			t.Fatalf("%s: %s", c.Name(), err)
+		}
+
+		if msg.ContentEncoding != c.Name() {
+			t.Errorf("%s: expected content encoding to be set, got %q", c.Name(), msg.ContentEncoding)
+		}
+
+		if len(msg.Payload) >= len(b) {
+			t.Errorf("%s: expected compressed payload smaller than %d bytes, got %d", c.Name(), len(b), len(msg.Payload))
+		}
+
+		var out Message
+		if err := msg.Decode(&out); err != nil {
+			t.Fatalf("%s: %s", c.Name(), err)
+		}
+
+		if out.Id != in.Id {
+			t.Errorf("%s: decoded payload does not match", c.Name())
+		}
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	msg := &Message{
+		Payload: []byte("small"),
+	}
+
+	if err := compress(msg, GzipCompressor, DefaultCompressionThreshold); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.ContentEncoding != "" {
+		t.Errorf("expected payload below threshold to be left uncompressed")
+	}
+
+	if string(msg.Payload) != "small" {
+		t.Errorf("expected payload to be unchanged, got %q", msg.Payload)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	defer func(n int) {
+		MaxDecompressedSize = n
+	}(MaxDecompressedSize)
+
+	MaxDecompressedSize = 1024
+
+	// Zeros compress to a small fraction of their size.
+	in := make([]byte, 64*1024)
+
+	// A new zstd compressor creates its decoder with the lowered limit.
+	for _, c := range []Compressor{GzipCompressor, SnappyCompressor, &zstdCompressor{}} {
+		b, err := c.Compress(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b) >= len(in) {
+			t.Fatalf("%s: expected compressed payload smaller than %d bytes, got %d", c.Name(), len(in), len(b))
+		}
+
+		if _, err := c.Decompress(b); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("%s: expected resource exhausted, got %v", c.Name(), err)
+		}
+	}
+}
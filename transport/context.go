@@ -0,0 +1,21 @@
+package transport
+
+import "context"
+
+type contextKey int
+
+const (
+	messageKey contextKey = iota
+)
+
+// NewContext returns a new context carrying the message.
+func NewContext(ctx context.Context, msg *Message) context.Context {
+	return context.WithValue(ctx, messageKey, msg)
+}
+
+// MessageFromContext returns the message carried by the context. Handler
+// contexts always carry the message being handled.
+func MessageFromContext(ctx context.Context) (*Message, bool) {
+	msg, ok := ctx.Value(messageKey).(*Message)
+	return msg, ok
+}
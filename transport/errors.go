@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/nats-io/go-nats"
+)
+
+// Handlers may return status errors with details, such as the types of the
+// errdetails package, which are sent to the requester in the status of the
+// reply. Detail types must be registered on both ends to be unpacked by
+// status.Details, those of errdetails are since it is imported here.
+
+// errorCodes maps errors of the connection and the context onto status codes.
+// Errors wrapping one of them are mapped as well.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+	{context.Canceled, codes.Canceled},
+
+	{nats.ErrTimeout, codes.DeadlineExceeded},
+	{nats.ErrConnectionClosed, codes.Unavailable},
+	{nats.ErrNoServers, codes.Unavailable},
+	{nats.ErrReconnectBufExceeded, codes.Unavailable},
+	{nats.ErrMaxPayload, codes.ResourceExhausted},
+	{nats.ErrSlowConsumer, codes.ResourceExhausted},
+	{nats.ErrBadSubject, codes.InvalidArgument},
+	{nats.ErrInvalidContext, codes.InvalidArgument},
+	{nats.ErrAuthorization, codes.PermissionDenied},
+}
+
+// noResponders is the start of the message of nats.ErrNoResponders, returned by
+// newer NATS clients when a request has no subscribers. The go-nats client
+// imported here predates both the sentinel and message headers, so neither
+// errors.Is nor the 503 status header of the reply can detect it, and the
+// message is the only thing to match when a newer client is used.
+const noResponders = "nats: no responders"
+
+// errorCode returns the code of the error or any error it wraps.
+func errorCode(err error) (codes.Code, bool) {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code, true
+		}
+	}
+
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if strings.HasPrefix(e.Error(), noResponders) {
+			return codes.Unavailable, true
+		}
+	}
+
+	return codes.Unknown, false
+}
+
+// errorStatus takes an error and returns the internal status or wraps it.
+// Errors of the connection and the context are mapped onto the corresponding
+// code with the original error preserved as a DebugInfo detail. Other errors
+// are Unknown.
+func errorStatus(err error) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+
+	// Check if this is a valid status, otherwise convert to one.
+	if sts, ok := status.FromError(err); ok {
+		return sts
+	}
+
+	code, ok := errorCode(err)
+	if !ok {
+		return status.New(codes.Unknown, err.Error())
+	}
+
+	sts := status.New(code, err.Error())
+
+	// This only fails if the detail cannot be marshaled, which is a bug.
+	if dsts, derr := sts.WithDetails(&errdetails.DebugInfo{Detail: err.Error()}); derr == nil {
+		sts = dsts
+	}
+
+	return sts
+}
+
+// statusError returns the error as a status error, see errorStatus.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return errorStatus(err).Err()
+}
@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/go-nats"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{nil, codes.OK},
+		{nats.ErrTimeout, codes.DeadlineExceeded},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{nats.ErrConnectionClosed, codes.Unavailable},
+		{nats.ErrNoServers, codes.Unavailable},
+		{nats.ErrMaxPayload, codes.ResourceExhausted},
+		{nats.ErrBadSubject, codes.InvalidArgument},
+		{status.Error(codes.NotFound, "missing"), codes.NotFound},
+		{errors.New("handler failed"), codes.Unknown},
+		{fmt.Errorf("flush: %w", nats.ErrTimeout), codes.DeadlineExceeded},
+		{fmt.Errorf("request: %w", context.Canceled), codes.Canceled},
+		{errors.New("nats: no responders available for request"), codes.Unavailable},
+		{fmt.Errorf("request: %w", errors.New("nats: no responders available for request")), codes.Unavailable},
+	}
+
+	for _, test := range tests {
+		if code := errorStatus(test.err).Code(); code != test.code {
+			t.Errorf("%v: expected %s, got %s", test.err, test.code, code)
+		}
+	}
+}
+
+func TestErrorStatusDetails(t *testing.T) {
+	sts := errorStatus(nats.ErrTimeout)
+
+	details := sts.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 detail, got %d", len(details))
+	}
+
+	info, ok := details[0].(*errdetails.DebugInfo)
+	if !ok {
+		t.Fatalf("expected debug info, got %T", details[0])
+	}
+
+	if info.Detail != nats.ErrTimeout.Error() {
+		t.Errorf("expected original error, got %q", info.Detail)
+	}
+}
+
+func TestErrorStatusWrapped(t *testing.T) {
+	err := fmt.Errorf("publish: %w", nats.ErrConnectionClosed)
+
+	sts := errorStatus(err)
+	if sts.Code() != codes.Unavailable {
+		t.Fatalf("expected unavailable, got %s", sts.Code())
+	}
+
+	// The wrapping error is preserved rather than the sentinel.
+	details := sts.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 detail, got %d", len(details))
+	}
+
+	if info := details[0].(*errdetails.DebugInfo); info.Detail != err.Error() {
+		t.Errorf("expected wrapping error, got %q", info.Detail)
+	}
+}
+
+func TestStatusDetailsRoundTrip(t *testing.T) {
+	sts, err := status.New(codes.InvalidArgument, "invalid request").WithDetails(
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "left", Description: "must be positive"},
+			},
+		},
+		&errdetails.ErrorInfo{Reason: "NEGATIVE", Domain: "example"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sent in the status of the reply envelope.
+	b, err := proto.Marshal(&Message{Status: errorStatus(sts.Err()).Proto()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	details := status.FromProto(msg.Status).Details()
+	if len(details) != 2 {
+		t.Fatalf("expected 2 details, got %d", len(details))
+	}
+
+	br, ok := details[0].(*errdetails.BadRequest)
+	if !ok {
+		t.Fatalf("expected bad request, got %T", details[0])
+	}
+
+	if br.FieldViolations[0].Field != "left" {
+		t.Errorf("expected field violation for left, got %s", br.FieldViolations[0].Field)
+	}
+
+	if info, ok := details[1].(*errdetails.ErrorInfo); !ok || info.Reason != "NEGATIVE" {
+		t.Errorf("expected error info, got %v", details[1])
+	}
+}
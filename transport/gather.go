@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/go-nats"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Reply is a reply collected by RequestAll.
+type Reply struct {
+	// Message is the reply message, which carries the metadata set by the
+	// responder.
+	Message *Message
+
+	// Value is the decoded payload. It is nil unless the status is OK.
+	Value proto.Message
+
+	// Status is the status of the reply or of decoding it.
+	Status *status.Status
+}
+
+// RequestCount sets the number of replies after which RequestAll returns.
+func RequestCount(n int) RequestOption {
+	return func(o *RequestOptions) {
+		o.Count = n
+	}
+}
+
+// RequestQuorum sets the number of successful replies after which
+// RequestAll returns. If it is not reached in time, the replies are
+// returned with an error.
+func RequestQuorum(n int) RequestOption {
+	return func(o *RequestOptions) {
+		o.Quorum = n
+	}
+}
+
+// RequestAll publishes the request once and collects the replies of every
+// subscriber until the count or quorum is reached, or the request times
+// out. Each reply is decoded into a new message of the type of rep, unless
+// rep is nil. Replies with an error status are returned along with the
+// others. An error is returned if the request cannot be sent, the quorum is
+// not reached, or the context is cancelled.
+func (c *transport) RequestAll(ctx context.Context, sub string, req proto.Message, rep proto.Message, opts ...RequestOption) ([]*Reply, error) {
+	reqOpts := &RequestOptions{
+		Timeout:    DefaultRequestTimeout,
+		Codec:      c.opts.Codec,
+		Compressor: c.opts.Compressor,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(reqOpts)
+	}
+
+	if reqOpts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, reqOpts.Timeout)
+		defer cancel()
+	}
+
+	m, err := c.wrap(req, reqOpts.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := compress(m, reqOpts.Compressor, c.opts.CompressionThreshold); err != nil {
+		return nil, err
+	}
+
+	m.Subject = sub
+	m.Cause = reqOpts.Cause
+	m.Metadata = reqOpts.Metadata
+	m.Reply = nats.NewInbox()
+
+	if dl, ok := ctx.Deadline(); ok {
+		m.Deadline = uint64(dl.UnixNano())
+	}
+
+	ctx, span := c.startClientSpan(ctx, m, trace.SpanKindClient)
+
+	start := time.Now()
+	c.opts.Metrics.ClientStarted(KindRequestAll, sub, len(m.Payload))
+
+	invoke := chainInvoker(
+		joinClientInterceptors(c.opts.ClientInterceptors, reqOpts.Interceptors),
+		c.publish,
+	)
+
+	replies, err := c.gather(ctx, m, rep, reqOpts, invoke)
+	err = statusError(err)
+	endSpan(span, err)
+
+	var size int
+	for _, r := range replies {
+		size += len(r.Message.Payload)
+	}
+
+	c.opts.Metrics.ClientHandled(KindRequestAll, sub, errorStatus(err).Code(), time.Since(start), size)
+
+	return replies, err
+}
+
+// gather subscribes to the reply inbox of the message, sends it and
+// collects the replies.
+func (c *transport) gather(ctx context.Context, m *Message, rep proto.Message, reqOpts *RequestOptions, invoke Invoker) ([]*Reply, error) {
+	msgs := make(chan *nats.Msg, 64)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Subscribe before the request is sent so no replies are missed.
+	s, err := c.conn.Subscribe(m.Reply, func(nmsg *nats.Msg) {
+		select {
+		case msgs <- nmsg:
+		case <-done:
+		}
+	})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	defer s.Unsubscribe()
+
+	if _, err := invoke(ctx, m); err != nil {
+		return nil, err
+	}
+
+	var (
+		replies []*Reply
+		ok      int
+	)
+
+	for {
+		if reqOpts.Count > 0 && len(replies) >= reqOpts.Count {
+			return replies, nil
+		}
+
+		if reqOpts.Quorum > 0 && ok >= reqOpts.Quorum {
+			return replies, nil
+		}
+
+		select {
+		case nmsg := <-msgs:
+			r, err := c.gatherReply(ctx, nmsg, rep)
+			if err != nil {
+				c.logger.Error("failed to decode reply",
+					zap.String("msg.subject", m.Subject),
+					zap.String("msg.id", m.Id),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			replies = append(replies, r)
+			if r.Status.Code() == codes.OK {
+				ok++
+			}
+
+		case <-ctx.Done():
+			if reqOpts.Quorum > 0 {
+				return replies, status.Error(errorStatus(ctx.Err()).Code(), fmt.Sprintf("quorum not reached: %d of %d replies succeeded", ok, reqOpts.Quorum))
+			}
+
+			// The timeout ends the collection, while a cancellation is an
+			// error.
+			if ctx.Err() == context.Canceled {
+				return replies, ctx.Err()
+			}
+
+			return replies, nil
+		}
+	}
+}
+
+// gatherReply unwraps and decodes a reply. An error is returned only if the
+// reply is not a transport message.
+func (c *transport) gatherReply(ctx context.Context, nmsg *nats.Msg, rep proto.Message) (*Reply, error) {
+	rm, err := c.unwrap(nmsg)
+	if err != nil {
+		return nil, err
+	}
+
+	if rm.Chunks > 0 {
+		full, err := c.reassemble(ctx, rm)
+		if err != nil {
+			return &Reply{
+				Message: rm,
+				Status:  errorStatus(err),
+			}, nil
+		}
+
+		rm = full
+	}
+
+	r := &Reply{
+		Message: rm,
+		Status:  status.FromProto(rm.Status),
+	}
+
+	// Deprecated.
+	// Older transports only set the error.
+	if rm.Status == nil && rm.Error != "" {
+		r.Status = status.New(codes.Unknown, rm.Error)
+	}
+
+	if r.Status.Code() != codes.OK || rep == nil {
+		return r, nil
+	}
+
+	v := proto.Clone(rep)
+	v.Reset()
+
+	if err := rm.Decode(v); err != nil {
+		r.Status = errorStatus(err)
+		return r, nil
+	}
+
+	r.Value = v
+
+	return r, nil
+}
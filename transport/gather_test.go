@@ -0,0 +1,115 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// subscribeAll subscribes n responders replying with their index, of
+// which the last fails.
+func subscribeAll(t *testing.T, tp Transport, n int) {
+	for i := 0; i < n; i++ {
+		i := i
+		hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+			if i == n-1 {
+				return nil, status.Error(codes.Internal, "broken")
+			}
+			return &Message{Subject: fmt.Sprint(i)}, nil
+		}
+
+		if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRequestAll(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	subscribeAll(t, tp, 3)
+
+	replies, err := tp.RequestAll(context.Background(), "_transport", nil, &Message{}, RequestCount(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replies) != 3 {
+		t.Fatalf("expected 3 replies, got %d", len(replies))
+	}
+
+	var ok int
+	for _, r := range replies {
+		switch r.Status.Code() {
+		case codes.OK:
+			ok++
+			if v := r.Value.(*Message); v.Subject != "0" && v.Subject != "1" {
+				t.Errorf("unexpected reply %q", v.Subject)
+			}
+
+		case codes.Internal:
+			if r.Value != nil {
+				t.Errorf("expected failed reply not to be decoded, got %v", r.Value)
+			}
+
+		default:
+			t.Errorf("unexpected status %v", r.Status)
+		}
+	}
+
+	if ok != 2 {
+		t.Errorf("expected 2 successful replies, got %d", ok)
+	}
+}
+
+func TestRequestAllTimeout(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	subscribeAll(t, tp, 2)
+
+	// Without a count, replies are collected until the timeout.
+	replies, err := tp.RequestAll(context.Background(), "_transport", nil, nil, RequestTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replies) != 2 {
+		t.Errorf("expected 2 replies, got %d", len(replies))
+	}
+}
+
+func TestRequestAllQuorum(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	subscribeAll(t, tp, 3)
+
+	replies, err := tp.RequestAll(context.Background(), "_transport", nil, nil, RequestQuorum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replies) < 2 {
+		t.Errorf("expected at least 2 replies, got %d", len(replies))
+	}
+
+	replies, err = tp.RequestAll(context.Background(), "_transport", nil, nil,
+		RequestQuorum(3),
+		RequestTimeout(50*time.Millisecond),
+	)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected quorum not to be reached, got %v", err)
+	}
+
+	if len(replies) != 3 {
+		t.Errorf("expected 3 replies, got %d", len(replies))
+	}
+}
@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+// RequestHedge sends a hedged copy of the request if it is not replied to
+// within the delay, and uses whichever reply is received first. The other
+// is no longer waited for. The copy has the same message id and Hedge set,
+// and is most useful with subscribers in a queue group, where it is likely
+// handled by another member. Only idempotent requests should be hedged.
+func RequestHedge(delay time.Duration) RequestOption {
+	return func(o *RequestOptions) {
+		o.HedgeDelay = delay
+	}
+}
+
+// hedged returns an invoker sending a hedged copy of the message if invoke
+// does not return within the delay.
+func (c *transport) hedged(delay time.Duration, invoke Invoker) Invoker {
+	return func(ctx context.Context, m *Message) (*Message, error) {
+		// Copied before the original is sent since invoke may modify it.
+		hm := proto.Clone(m).(*Message)
+		hm.Hedge = true
+
+		// Cancels the copy whose reply is not used.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type result struct {
+			rm    *Message
+			err   error
+			hedge bool
+		}
+
+		results := make(chan result, 2)
+
+		send := func(m *Message) {
+			rm, err := invoke(ctx, m)
+			results <- result{rm, err, m.Hedge}
+		}
+
+		go send(m)
+
+		t := time.NewTimer(delay)
+		defer t.Stop()
+
+		select {
+		case r := <-results:
+			return r.rm, r.err
+		case <-t.C:
+		}
+
+		c.logger.Debug("hedging request",
+			zap.String("msg.subject", m.Subject),
+			zap.String("msg.id", m.Id),
+			zap.Duration("delay", delay),
+		)
+
+		go send(hm)
+
+		// A reply is used even if it has an error status, while a failure
+		// to get one waits for the other copy.
+		r := <-results
+		if r.rm == nil {
+			if o := <-results; o.rm != nil {
+				r = o
+			}
+		}
+
+		c.opts.Metrics.ClientHedged(m.Subject, r.rm != nil && r.hedge)
+
+		return r.rm, r.err
+	}
+}
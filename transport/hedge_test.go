@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// hedgeMetrics records hedged requests.
+type hedgeMetrics struct {
+	nopMetrics
+	won chan bool
+}
+
+func (m *hedgeMetrics) ClientHedged(subject string, won bool) {
+	m.won <- won
+}
+
+func TestRequestHedge(t *testing.T) {
+	metrics := &hedgeMetrics{won: make(chan bool, 1)}
+
+	tp := NewMemory(WithMetrics(metrics))
+	defer tp.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		// The original is stuck.
+		if !msg.Hedge {
+			<-release
+		}
+		return &Message{Subject: "hedge"}, nil
+	}
+
+	// Members of a queue group handle the original and the copy.
+	for i := 0; i < 2; i++ {
+		if _, err := tp.Subscribe("_transport", hdlr, SubscribeQueue("q"), SubscribeConcurrency(2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rep Message
+	_, err := tp.Request(context.Background(), "_transport", nil, &rep,
+		RequestHedge(20*time.Millisecond),
+		RequestTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Subject != "hedge" {
+		t.Errorf("expected reply to the hedged copy, got %q", rep.Subject)
+	}
+
+	if won := <-metrics.won; !won {
+		t.Error("expected the hedged copy to be reported as won")
+	}
+}
+
+func TestRequestHedgeNotSent(t *testing.T) {
+	metrics := &hedgeMetrics{won: make(chan bool, 1)}
+
+	tp := NewMemory(WithMetrics(metrics))
+	defer tp.Close()
+
+	var hedges int
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		if msg.Hedge {
+			hedges++
+		}
+		return nil, nil
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tp.Request(context.Background(), "_transport", nil, nil, RequestHedge(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if hedges != 0 {
+		t.Errorf("expected no hedged copy, got %d", hedges)
+	}
+
+	select {
+	case <-metrics.won:
+		t.Error("expected no hedge to be reported")
+	default:
+	}
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Invoker sends an outgoing message. For requests, the reply message is
+// returned along with the reply status as an error. For publications, the
+// returned message is nil.
+type Invoker func(ctx context.Context, msg *Message) (*Message, error)
+
+// ClientInterceptor intercepts outgoing publications and requests. The message
+// has been wrapped, but not sent. It may be inspected or modified before calling
+// invoker to continue the chain.
+type ClientInterceptor func(ctx context.Context, msg *Message, invoker Invoker) (*Message, error)
+
+// ServerInterceptor intercepts incoming messages of a subscription. It may
+// inspect the message and context before calling hdlr to continue the chain.
+type ServerInterceptor func(ctx context.Context, msg *Message, hdlr Handler) (proto.Message, error)
+
+// ChainClientInterceptors returns a single interceptor calling the interceptors
+// in order, the first being the outermost.
+func ChainClientInterceptors(interceptors ...ClientInterceptor) ClientInterceptor {
+	return func(ctx context.Context, msg *Message, invoker Invoker) (*Message, error) {
+		return chainInvoker(interceptors, invoker)(ctx, msg)
+	}
+}
+
+// ChainServerInterceptors returns a single interceptor calling the interceptors
+// in order, the first being the outermost.
+func ChainServerInterceptors(interceptors ...ServerInterceptor) ServerInterceptor {
+	return func(ctx context.Context, msg *Message, hdlr Handler) (proto.Message, error) {
+		return chainHandler(interceptors, hdlr)(ctx, msg)
+	}
+}
+
+// chainInvoker wraps the invoker with the interceptors.
+func chainInvoker(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next, ic := invoker, interceptors[i]
+		invoker = func(ctx context.Context, msg *Message) (*Message, error) {
+			return ic(ctx, msg, next)
+		}
+	}
+
+	return invoker
+}
+
+// chainHandler wraps the handler with the interceptors.
+func chainHandler(interceptors []ServerInterceptor, hdlr Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next, ic := hdlr, interceptors[i]
+		hdlr = func(ctx context.Context, msg *Message) (proto.Message, error) {
+			return ic(ctx, msg, next)
+		}
+	}
+
+	return hdlr
+}
+
+// joinClientInterceptors returns the transport interceptors followed by
+// the call interceptors without modifying either.
+func joinClientInterceptors(transport, call []ClientInterceptor) []ClientInterceptor {
+	out := make([]ClientInterceptor, 0, len(transport)+len(call))
+	out = append(out, transport...)
+	return append(out, call...)
+}
+
+// joinServerInterceptors returns the transport interceptors followed by
+// the subscription interceptors without modifying either.
+func joinServerInterceptors(transport, sub []ServerInterceptor) []ServerInterceptor {
+	out := make([]ServerInterceptor, 0, len(transport)+len(sub))
+	out = append(out, transport...)
+	return append(out, sub...)
+}
@@ -0,0 +1,338 @@
+package transport
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/ptypes"
+)
+
+// RateLimit limits the rate at which messages are handled using a token
+// bucket.
+type RateLimit struct {
+	// Rate is the number of messages per second.
+	Rate float64
+
+	// Burst is the number of messages that can be handled at once above the
+	// rate. Defaults to one.
+	Burst int
+
+	// Key is a metadata key, such as a caller id, messages are limited by
+	// separately. Messages without the key share a bucket. If empty, all
+	// messages share a bucket.
+	Key string
+}
+
+// SubscribeRateLimit limits the rate of all messages of the subscription.
+// Messages above the rate are rejected with codes.ResourceExhausted and a
+// RetryInfo detail with the time until the next message is allowed.
+func SubscribeRateLimit(rate float64, burst int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.RateLimits = append(o.RateLimits, RateLimit{
+			Rate:  rate,
+			Burst: burst,
+		})
+	}
+}
+
+// SubscribeRateLimitBy limits the rate of messages separately by the value
+// of a metadata key, such as a caller id. It can be combined with a rate
+// limit of all messages set by SubscribeRateLimit.
+func SubscribeRateLimitBy(key string, rate float64, burst int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.RateLimits = append(o.RateLimits, RateLimit{
+			Rate:  rate,
+			Burst: burst,
+			Key:   key,
+		})
+	}
+}
+
+// LoadShedding sets the thresholds above which a subscription rejects
+// messages with codes.Unavailable, so they can be retried by a less loaded
+// member of the queue group.
+type LoadShedding struct {
+	// MaxInFlight is the number of messages handled at once above which
+	// messages are rejected. Zero disables the limit.
+	MaxInFlight int
+
+	// MaxLatency is the target moving average of the handler latency. Above
+	// it, a fraction of messages growing with the average is rejected. Zero
+	// disables the target. Streams are not included in the average.
+	MaxLatency time.Duration
+}
+
+// SubscribeLoadShedding enables load shedding of the subscription.
+func SubscribeLoadShedding(s LoadShedding) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.LoadShedding = &s
+	}
+}
+
+const (
+	// maxRateLimitKeys is the number of keyed buckets above which full
+	// buckets are removed.
+	maxRateLimitKeys = 10000
+
+	// latencyWeight is the weight of a new latency in the moving average.
+	latencyWeight = 0.1
+
+	// minShedRetryDelay is the lower bound of the retry delay of shed
+	// messages.
+	minShedRetryDelay = 10 * time.Millisecond
+)
+
+// tokenBucket is a token bucket filled at rate tokens per second up to burst.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// fill adds the tokens accrued since the last fill.
+func (b *tokenBucket) fill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take takes a token. If none is available, the time until one is is
+// returned.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.fill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if b.rate <= 0 {
+		return false, time.Second
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter holds the buckets of a rate limit.
+type rateLimiter struct {
+	limit RateLimit
+
+	mux     sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   l,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// key returns the key of the bucket of the message.
+func (r *rateLimiter) key(msg *Message) string {
+	if r.limit.Key == "" {
+		return ""
+	}
+	return msg.Metadata[r.limit.Key]
+}
+
+// take takes a token from the bucket of the message.
+func (r *rateLimiter) take(msg *Message, now time.Time) (bool, time.Duration) {
+	key := r.key(msg)
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	b, ok := r.buckets[key]
+	if !ok {
+		if len(r.buckets) >= maxRateLimitKeys {
+			r.sweep(now)
+		}
+
+		b = newTokenBucket(r.limit.Rate, r.limit.Burst, now)
+		r.buckets[key] = b
+	}
+
+	return b.take(now)
+}
+
+// refund returns a token taken for a message that was rejected by another
+// limit.
+func (r *rateLimiter) refund(msg *Message) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if b, ok := r.buckets[r.key(msg)]; ok {
+		b.tokens = math.Min(b.burst, b.tokens+1)
+	}
+}
+
+// sweep removes full buckets, which behave the same as new ones.
+func (r *rateLimiter) sweep(now time.Time) {
+	for k, b := range r.buckets {
+		b.fill(now)
+		if b.tokens >= b.burst {
+			delete(r.buckets, k)
+		}
+	}
+}
+
+// shedder tracks the load of a subscription.
+type shedder struct {
+	opts LoadShedding
+
+	// rand returns the number compared to the fraction of messages shed.
+	rand func() float64
+
+	mux      sync.Mutex
+	inflight int
+	latency  time.Duration
+}
+
+func newShedder(opts LoadShedding) *shedder {
+	return &shedder{
+		opts: opts,
+		rand: rand.Float64,
+	}
+}
+
+// begin registers a message being handled unless it is shed, in which case
+// a retry delay is returned.
+func (s *shedder) begin() (bool, time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	shed := s.opts.MaxInFlight > 0 && s.inflight >= s.opts.MaxInFlight
+
+	// The fraction shed approaches one as the average latency grows past
+	// the target.
+	if !shed && s.opts.MaxLatency > 0 && s.latency > s.opts.MaxLatency {
+		shed = s.rand() < 1-float64(s.opts.MaxLatency)/float64(s.latency)
+	}
+
+	if shed {
+		delay := s.latency
+		if delay < minShedRetryDelay {
+			delay = minShedRetryDelay
+		}
+		return false, delay
+	}
+
+	s.inflight++
+	return true, 0
+}
+
+// end unregisters a message being handled. The latency is added to the
+// moving average unless it is zero.
+func (s *shedder) end(latency time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.inflight--
+
+	if latency > 0 {
+		if s.latency == 0 {
+			s.latency = latency
+		} else {
+			s.latency += time.Duration(latencyWeight * float64(latency-s.latency))
+		}
+	}
+}
+
+// limiter admits the messages of a subscription before they are handled.
+type limiter struct {
+	rates []*rateLimiter
+	shed  *shedder
+}
+
+// newLimiter returns a limiter for the subscription options or nil if no
+// limits are set.
+func newLimiter(o *SubscribeOptions) *limiter {
+	if len(o.RateLimits) == 0 && o.LoadShedding == nil {
+		return nil
+	}
+
+	l := &limiter{}
+
+	for _, r := range o.RateLimits {
+		l.rates = append(l.rates, newRateLimiter(r))
+	}
+
+	if o.LoadShedding != nil {
+		l.shed = newShedder(*o.LoadShedding)
+	}
+
+	return l
+}
+
+// admit returns a status rejecting the message or nil if it is admitted, in
+// which case done must be called once it is handled. A rejected message does
+// not use up the tokens of the limits it passed.
+func (l *limiter) admit(msg *Message) *status.Status {
+	now := time.Now()
+
+	for i, r := range l.rates {
+		if ok, wait := r.take(msg, now); !ok {
+			l.refund(msg, i)
+			return retryStatus(codes.ResourceExhausted, "rate limit exceeded", wait)
+		}
+	}
+
+	if l.shed != nil {
+		if ok, wait := l.shed.begin(); !ok {
+			l.refund(msg, len(l.rates))
+			return retryStatus(codes.Unavailable, "overloaded", wait)
+		}
+	}
+
+	return nil
+}
+
+// refund returns the tokens taken from the first n rate limits.
+func (l *limiter) refund(msg *Message, n int) {
+	for _, r := range l.rates[:n] {
+		r.refund(msg)
+	}
+}
+
+// done records the latency of an admitted message. Zero is passed for
+// streams.
+func (l *limiter) done(latency time.Duration) {
+	if l.shed != nil {
+		l.shed.end(latency)
+	}
+}
+
+// retryStatus returns a status with a RetryInfo detail.
+func retryStatus(code codes.Code, msg string, wait time.Duration) *status.Status {
+	sts := status.New(code, msg)
+
+	if dsts, err := sts.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(wait),
+	}); err == nil {
+		sts = dsts
+	}
+
+	return sts
+}
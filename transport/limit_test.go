@@ -0,0 +1,269 @@
+package transport
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/go-nats"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2, now)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.take(now); !ok {
+			t.Fatalf("expected burst token %d to be taken", i+1)
+		}
+	}
+
+	ok, wait := b.take(now)
+	if ok {
+		t.Fatal("expected bucket to be empty")
+	}
+	if wait != 100*time.Millisecond {
+		t.Errorf("expected to wait 100ms, got %s", wait)
+	}
+
+	if ok, _ := b.take(now.Add(100 * time.Millisecond)); !ok {
+		t.Error("expected a token after 100ms")
+	}
+
+	// The bucket does not fill past the burst.
+	later := now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		b.take(later)
+	}
+	if ok, _ := b.take(later); ok {
+		t.Error("expected bucket to hold at most the burst")
+	}
+}
+
+func TestRateLimiterKey(t *testing.T) {
+	r := newRateLimiter(RateLimit{Rate: 1, Burst: 1, Key: "caller"})
+	now := time.Now()
+
+	alice := &Message{Metadata: map[string]string{"caller": "alice"}}
+	bob := &Message{Metadata: map[string]string{"caller": "bob"}}
+
+	if ok, _ := r.take(alice, now); !ok {
+		t.Fatal("expected first message of alice to be allowed")
+	}
+	if ok, _ := r.take(alice, now); ok {
+		t.Error("expected second message of alice to be limited")
+	}
+	if ok, _ := r.take(bob, now); !ok {
+		t.Error("expected bob to be limited separately")
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	l := newLimiter(&SubscribeOptions{
+		RateLimits: []RateLimit{
+			{Rate: 0.001, Burst: 2, Key: "caller"},
+			{Rate: 0.001, Burst: 1},
+		},
+	})
+
+	alice := &Message{Metadata: map[string]string{"caller": "alice"}}
+
+	if sts := l.admit(alice); sts != nil {
+		t.Fatal(sts.Err())
+	}
+
+	// Rejected by the second limit, the token of the first is returned.
+	if sts := l.admit(alice); sts.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected resource exhausted, got %v", sts.Err())
+	}
+
+	if b := l.rates[0].buckets["alice"]; b.tokens < 1 {
+		t.Errorf("expected the token of the rejected message to be returned, got %.2f", b.tokens)
+	}
+
+	// Shed messages are refunded as well.
+	l = newLimiter(&SubscribeOptions{
+		RateLimits:   []RateLimit{{Rate: 0.001, Burst: 2}},
+		LoadShedding: &LoadShedding{MaxInFlight: 1},
+	})
+
+	if sts := l.admit(alice); sts != nil {
+		t.Fatal(sts.Err())
+	}
+	if sts := l.admit(alice); sts.Code() != codes.Unavailable {
+		t.Fatalf("expected unavailable, got %v", sts.Err())
+	}
+
+	l.done(0)
+
+	if sts := l.admit(alice); sts != nil {
+		t.Errorf("expected the token of the shed message to be returned, got %v", sts.Err())
+	}
+}
+
+func TestShedder(t *testing.T) {
+	s := newShedder(LoadShedding{
+		MaxInFlight: 1,
+		MaxLatency:  10 * time.Millisecond,
+	})
+	s.rand = func() float64 { return 0.5 }
+
+	if ok, _ := s.begin(); !ok {
+		t.Fatal("expected message to be admitted")
+	}
+	if ok, _ := s.begin(); ok {
+		t.Error("expected message above the in-flight limit to be shed")
+	}
+
+	// Half of the messages are shed at twice the target latency.
+	s.end(15 * time.Millisecond)
+	if ok, _ := s.begin(); !ok {
+		t.Error("expected message to be admitted below the shed fraction")
+	}
+	s.end(0)
+
+	s.latency = 40 * time.Millisecond
+	ok, wait := s.begin()
+	if ok {
+		t.Error("expected message to be shed above the shed fraction")
+	}
+	if wait != 40*time.Millisecond {
+		t.Errorf("expected retry delay of the average latency, got %s", wait)
+	}
+}
+
+func TestSubscribeRateLimit(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		return nil, nil
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr, SubscribeRateLimit(0.1, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tp.Request(context.Background(), "_transport", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := tp.Request(context.Background(), "_transport", nil, nil)
+
+	sts := status.Convert(err)
+	if sts.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected resource exhausted, got %v", err)
+	}
+
+	details := sts.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 detail, got %d", len(details))
+	}
+
+	if info, ok := details[0].(*errdetails.RetryInfo); !ok || info.RetryDelay.Seconds < 9 {
+		t.Errorf("expected retry info of about 10s, got %v", details[0])
+	}
+}
+
+func TestSubscribeLoadShedding(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	var called int
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		called++
+		close(started)
+		<-release
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr,
+		SubscribeConcurrency(2),
+		SubscribeLoadShedding(LoadShedding{MaxInFlight: 1}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	_, err = tp.Request(context.Background(), "_transport", nil, nil, RequestTimeout(time.Second))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable, got %v", err)
+	}
+
+	if called != 1 {
+		t.Errorf("expected the handler to be called once, got %d", called)
+	}
+}
+
+// fetchBroker counts the chunks fetched through the broker. Chunks are
+// fetched before the message is handled, so the count is settled once the
+// reply is received.
+type fetchBroker struct {
+	broker
+	fetched int32
+}
+
+func (b *fetchBroker) RequestWithContext(ctx context.Context, subject string, data []byte) (*nats.Msg, error) {
+	if strings.HasPrefix(subject, chunkPrefix) {
+		atomic.AddInt32(&b.fetched, 1)
+	}
+	return b.broker.RequestWithContext(ctx, subject, data)
+}
+
+func TestSubscribeRateLimitChunked(t *testing.T) {
+	mb := NewMemoryBroker()
+	mb.MaxPayload = 1024
+
+	tp := mb.Connect().(*transport)
+	defer tp.Close()
+
+	b := &fetchBroker{broker: tp.conn}
+	tp.conn = b
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		return nil, nil
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr, SubscribeRateLimitBy("caller", 0.1, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &Message{Id: strings.Repeat("x", 4096)}
+	md := RequestMetadata(Metadata{"caller": "alice"})
+
+	if _, err := tp.Request(context.Background(), "_transport", req, nil, md); err != nil {
+		t.Fatal(err)
+	}
+
+	n := atomic.LoadInt32(&b.fetched)
+	if n == 0 {
+		t.Fatal("expected chunks to be fetched")
+	}
+
+	// The limit applies by the metadata of the header, before the chunks
+	// are fetched.
+	_, err := tp.Request(context.Background(), "_transport", req, nil, md)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected resource exhausted, got %v", err)
+	}
+
+	if m := atomic.LoadInt32(&b.fetched); m != n {
+		t.Errorf("expected no chunks of the rejected message to be fetched, got %d", m-n)
+	}
+}
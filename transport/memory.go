@@ -0,0 +1,394 @@
+package transport
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/go-nats"
+)
+
+// DefaultMemoryMaxPayload is the default maximum payload of a MemoryBroker,
+// matching the default of a NATS server.
+const DefaultMemoryMaxPayload = 1 << 20
+
+// MemoryBroker is an in-process broker with the NATS semantics used by the
+// transport: subject wildcards, queue groups and request/reply. It allows
+// clients and servers to be tested without a NATS server.
+//
+//	b := transport.NewMemoryBroker()
+//	svc := example.NewServer(b.Connect(), example.NewService())
+//	client := example.NewClient(b.Connect())
+type MemoryBroker struct {
+	// MaxPayload is the maximum size of a message published through the
+	// broker. Larger messages are sent in chunks by the transport.
+	MaxPayload int64
+
+	mux  sync.RWMutex
+	subs map[*memorySub]struct{}
+}
+
+// NewMemoryBroker returns a new broker without subscriptions.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		MaxPayload: DefaultMemoryMaxPayload,
+		subs:       make(map[*memorySub]struct{}),
+	}
+}
+
+// Connect returns a transport connected to the broker. Closing the transport
+// removes its subscriptions from the broker.
+func (b *MemoryBroker) Connect(opts ...Option) Transport {
+	conn := &memoryConn{
+		b:    b,
+		subs: make(map[*memorySub]struct{}),
+		done: make(chan struct{}),
+	}
+
+	return open(nil, conn, opts)
+}
+
+// NewMemory returns a transport connected to a new MemoryBroker. Clients and
+// servers using the same transport can communicate.
+func NewMemory(opts ...Option) Transport {
+	return NewMemoryBroker().Connect(opts...)
+}
+
+// publish delivers the message to every matching subscription, and to one
+// member of each matching queue group.
+func (b *MemoryBroker) publish(subject, reply string, data []byte) {
+	tokens := strings.Split(subject, ".")
+
+	b.mux.RLock()
+	var (
+		subs   []*memorySub
+		queues map[string][]*memorySub
+	)
+
+	for s := range b.subs {
+		if !matchSubject(s.tokens, tokens) {
+			continue
+		}
+
+		if s.queue == "" {
+			subs = append(subs, s)
+			continue
+		}
+
+		if queues == nil {
+			queues = make(map[string][]*memorySub)
+		}
+		queues[s.queue] = append(queues[s.queue], s)
+	}
+	b.mux.RUnlock()
+
+	for _, qs := range queues {
+		subs = append(subs, qs[rand.Intn(len(qs))])
+	}
+
+	for _, s := range subs {
+		s.enqueue(&nats.Msg{
+			Subject: subject,
+			Reply:   reply,
+			Data:    data,
+			Sub:     s.nsub,
+		})
+	}
+}
+
+// memoryConn is a connection to a MemoryBroker.
+type memoryConn struct {
+	b      *MemoryBroker
+	mux    sync.Mutex
+	subs   map[*memorySub]struct{}
+	closed bool
+	done   chan struct{}
+}
+
+func (c *memoryConn) Publish(subject string, data []byte) error {
+	return c.PublishRequest(subject, "", data)
+}
+
+func (c *memoryConn) PublishRequest(subject, reply string, data []byte) error {
+	if c.isClosed() {
+		return nats.ErrConnectionClosed
+	}
+
+	if !validSubject(subject, false) {
+		return nats.ErrBadSubject
+	}
+
+	if int64(len(data)) > c.MaxPayload() {
+		return nats.ErrMaxPayload
+	}
+
+	// The publisher may reuse the buffer.
+	b := make([]byte, len(data))
+	copy(b, data)
+
+	c.b.publish(subject, reply, b)
+	return nil
+}
+
+func (c *memoryConn) RequestWithContext(ctx context.Context, subject string, data []byte) (*nats.Msg, error) {
+	if ctx == nil {
+		return nil, nats.ErrInvalidContext
+	}
+
+	inbox := nats.NewInbox()
+	replies := make(chan *nats.Msg, 1)
+
+	sub, err := c.Subscribe(inbox, func(m *nats.Msg) {
+		select {
+		case replies <- m:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	if err := c.PublishRequest(subject, inbox, data); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m := <-replies:
+		return m, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, nats.ErrConnectionClosed
+	}
+}
+
+func (c *memoryConn) Subscribe(subject string, cb nats.MsgHandler) (brokerSubscription, error) {
+	return c.QueueSubscribe(subject, "", cb)
+}
+
+func (c *memoryConn) QueueSubscribe(subject, queue string, cb nats.MsgHandler) (brokerSubscription, error) {
+	if !validSubject(subject, true) {
+		return nil, nats.ErrBadSubject
+	}
+
+	s := &memorySub{
+		conn:   c,
+		tokens: strings.Split(subject, "."),
+		queue:  queue,
+		cb:     cb,
+		done:   make(chan struct{}),
+		nsub: &nats.Subscription{
+			Subject: subject,
+			Queue:   queue,
+		},
+	}
+	s.cond = sync.NewCond(&s.mux)
+
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return nil, nats.ErrConnectionClosed
+	}
+	c.subs[s] = struct{}{}
+	c.mux.Unlock()
+
+	c.b.mux.Lock()
+	c.b.subs[s] = struct{}{}
+	c.b.mux.Unlock()
+
+	go s.run()
+
+	return s, nil
+}
+
+func (c *memoryConn) MaxPayload() int64 {
+	return c.b.MaxPayload
+}
+
+func (c *memoryConn) Close() {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.done)
+
+	subs := c.subs
+	c.subs = nil
+	c.mux.Unlock()
+
+	for s := range subs {
+		s.close()
+	}
+}
+
+func (c *memoryConn) isClosed() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.closed
+}
+
+// memorySub is a subscription of a memoryConn. Like NATS, messages are
+// delivered to the callback one at a time in the order they were published.
+type memorySub struct {
+	conn   *memoryConn
+	tokens []string
+	queue  string
+	cb     nats.MsgHandler
+
+	// nsub is set on delivered messages for their subject and queue.
+	nsub *nats.Subscription
+
+	mux      sync.Mutex
+	cond     *sync.Cond
+	pending  []*nats.Msg
+	closed   bool
+	draining bool
+
+	// done is closed once messages are no longer delivered.
+	done chan struct{}
+}
+
+func (s *memorySub) Unsubscribe() error {
+	if !s.detach() {
+		return nats.ErrBadSubscription
+	}
+
+	s.close()
+	return nil
+}
+
+// Drain removes the subscription from the broker and waits for pending
+// messages to be delivered.
+func (s *memorySub) Drain(ctx context.Context) error {
+	if !s.detach() {
+		return nats.ErrBadSubscription
+	}
+
+	s.remove()
+
+	s.mux.Lock()
+	s.draining = true
+	s.mux.Unlock()
+	s.cond.Signal()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// detach removes the subscription from its connection. It returns false if
+// the subscription was already removed.
+func (s *memorySub) detach() bool {
+	c := s.conn
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if _, ok := c.subs[s]; !ok {
+		return false
+	}
+	delete(c.subs, s)
+	return true
+}
+
+// remove removes the subscription from the broker so it receives no more
+// messages.
+func (s *memorySub) remove() {
+	b := s.conn.b
+
+	b.mux.Lock()
+	delete(b.subs, s)
+	b.mux.Unlock()
+}
+
+// close removes the subscription from the broker. Pending messages are
+// dropped.
+func (s *memorySub) close() {
+	s.remove()
+
+	s.mux.Lock()
+	s.closed = true
+	s.pending = nil
+	s.mux.Unlock()
+	s.cond.Signal()
+}
+
+func (s *memorySub) enqueue(m *nats.Msg) {
+	s.mux.Lock()
+	if !s.closed {
+		s.pending = append(s.pending, m)
+	}
+	s.mux.Unlock()
+	s.cond.Signal()
+}
+
+func (s *memorySub) run() {
+	defer close(s.done)
+
+	for {
+		s.mux.Lock()
+		for len(s.pending) == 0 && !s.closed && !s.draining {
+			s.cond.Wait()
+		}
+
+		// A draining subscription stops once its pending messages are
+		// delivered.
+		if s.closed || len(s.pending) == 0 {
+			s.mux.Unlock()
+			return
+		}
+
+		m := s.pending[0]
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		s.mux.Unlock()
+
+		s.cb(m)
+	}
+}
+
+// validSubject returns true if the subject consists of non-empty tokens.
+// If wildcards are allowed, a token may be * and the last token may be >.
+func validSubject(subject string, wildcards bool) bool {
+	if subject == "" {
+		return false
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i, t := range tokens {
+		switch {
+		case t == "":
+			return false
+		case t == "*" || t == ">":
+			if !wildcards || (t == ">" && i != len(tokens)-1) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matchSubject returns true if the subject matches the pattern, both given
+// as tokens. A * token matches any single token and a > token matches one
+// or more remaining tokens.
+func matchSubject(pattern, subject []string) bool {
+	for i, p := range pattern {
+		if p == ">" {
+			return len(subject) > i
+		}
+
+		if i >= len(subject) || (p != "*" && p != subject[i]) {
+			return false
+		}
+	}
+
+	return len(pattern) == len(subject)
+}
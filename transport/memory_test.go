@@ -0,0 +1,175 @@
+package transport
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Subject string
+		Match   bool
+	}{
+		{"foo.bar", "foo.bar", true},
+		{"foo.bar", "foo.baz", false},
+		{"foo.*", "foo.bar", true},
+		{"foo.*", "foo.bar.baz", false},
+		{"*.bar", "foo.bar", true},
+		{"foo.>", "foo.bar.baz", true},
+		{"foo.>", "foo", false},
+		{">", "foo", true},
+	}
+
+	for _, test := range tests {
+		match := matchSubject(strings.Split(test.Pattern, "."), strings.Split(test.Subject, "."))
+		if match != test.Match {
+			t.Errorf("%s matching %s: expected %v", test.Pattern, test.Subject, test.Match)
+		}
+	}
+}
+
+func TestMemoryRequest(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	_, err := tp.Subscribe("_transport.*", func(ctx context.Context, msg *Message) (proto.Message, error) {
+		return &Message{Id: msg.Subject}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep Message
+	if _, err := tp.Request(context.Background(), "_transport.sum", nil, &rep); err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Id != "_transport.sum" {
+		t.Errorf("expected reply from wildcard subscriber, got %q", rep.Id)
+	}
+}
+
+func TestMemoryChunking(t *testing.T) {
+	b := NewMemoryBroker()
+	b.MaxPayload = 1024
+
+	tp := b.Connect()
+	defer tp.Close()
+
+	_, err := tp.Subscribe("_transport", func(ctx context.Context, msg *Message) (proto.Message, error) {
+		var req Message
+		if err := msg.Decode(&req); err != nil {
+			return nil, err
+		}
+		return &req, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &Message{Id: strings.Repeat("x", 4096)}
+
+	var rep Message
+	if _, err := tp.Request(context.Background(), "_transport", req, &rep); err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Id != req.Id {
+		t.Errorf("expected reply to match request")
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	var (
+		mux    sync.Mutex
+		counts = make(map[string]int)
+		wg     sync.WaitGroup
+	)
+
+	count := func(name string) Handler {
+		return func(ctx context.Context, msg *Message) (proto.Message, error) {
+			mux.Lock()
+			counts[name]++
+			mux.Unlock()
+			wg.Done()
+			return nil, nil
+		}
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := tp.Subscribe("_transport", count(name), SubscribeQueue("workers")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := tp.Subscribe("_transport", count("all")); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 100
+	wg.Add(2 * n)
+
+	for i := 0; i < n; i++ {
+		if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wg.Wait()
+
+	if counts["all"] != n {
+		t.Errorf("expected %d messages for the plain subscriber, got %d", n, counts["all"])
+	}
+
+	if counts["a"]+counts["b"] != n {
+		t.Errorf("expected %d messages for the queue group, got %d", n, counts["a"]+counts["b"])
+	}
+
+	if counts["a"] == 0 || counts["b"] == 0 {
+		t.Errorf("expected messages to be balanced across the queue group, got %v", counts)
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	b := NewMemoryBroker()
+
+	stp := b.Connect()
+	_, err := stp.Subscribe("_transport", func(ctx context.Context, msg *Message) (proto.Message, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := b.Connect()
+	defer tp.Close()
+
+	if _, err := tp.Request(context.Background(), "_transport", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stp.Close()
+
+	// The subscription is removed so the request is not answered.
+	_, err = tp.Request(context.Background(), "_transport", nil, nil, RequestTimeout(50*time.Millisecond))
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	_, err = stp.Request(context.Background(), "_transport", nil, nil)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable from closed transport, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package transport
+
+import "context"
+
+// Metadata is a set of key/value pairs sent along with a message.
+type Metadata map[string]string
+
+// merge returns md with the pairs of other added. md is allocated if nil.
+func (md Metadata) merge(other Metadata) Metadata {
+	if len(other) == 0 {
+		return md
+	}
+
+	if md == nil {
+		md = make(Metadata, len(other))
+	}
+
+	for k, v := range other {
+		md[k] = v
+	}
+
+	return md
+}
+
+type outgoingMetadataKey struct{}
+
+// NewOutgoingContext returns a new context carrying metadata to be sent with
+// requests made using it. It replaces any outgoing metadata already set.
+// Generated clients forward this metadata automatically.
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingMetadataKey{}, md)
+}
+
+// OutgoingMetadataFromContext returns the outgoing metadata carried by the context.
+func OutgoingMetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+// IncomingMetadataFromContext returns the metadata of the message carried by
+// a handler context.
+func IncomingMetadataFromContext(ctx context.Context) (Metadata, bool) {
+	msg, ok := MessageFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	return Metadata(msg.Metadata), true
+}
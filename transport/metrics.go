@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// KindPublish, KindRequest, KindRequestAll and KindStream are the kinds
+	// of client calls passed to Metrics.
+	KindPublish    = "publish"
+	KindRequest    = "request"
+	KindRequestAll = "request_all"
+	KindStream     = "stream"
+)
+
+// Metrics records metrics of the traffic of a transport. Sizes are of the
+// encoded, possibly compressed, payload. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// ClientStarted is called when a publication or request is sent or a
+	// stream is opened.
+	ClientStarted(kind, subject string, size int)
+
+	// ClientHandled is called when a publication, request or stream completes
+	// with the status code of the result and the size of the reply payload.
+	ClientHandled(kind, subject string, code codes.Code, d time.Duration, size int)
+
+	// ClientHedged is called when a request for which a hedged copy was
+	// sent completes, with whether the reply to the copy was used, see
+	// RequestHedge.
+	ClientHedged(subject string, won bool)
+
+	// ServerStarted is called when a subscription handler starts handling
+	// a message.
+	ServerStarted(subject, queue string, size int)
+
+	// ServerHandled is called when a subscription handler is done handling
+	// a message with the kind of call it was sent by, the status code of the
+	// result and the size of the reply payload. Publications are not replied
+	// to.
+	ServerHandled(kind, subject, queue string, code codes.Code, d time.Duration, size int)
+
+	// ServerPending is called with the number of messages queued for the
+	// workers of a subscription when it changes, see SubscribeConcurrency.
+	// The subject is that of the subscription.
+	ServerPending(subject, queue string, n int)
+
+	// ServerDropped is called when a message is dropped because the
+	// pending limit of a subscription is reached. The subject is that of
+	// the subscription.
+	ServerDropped(subject, queue string)
+}
+
+// WithMetrics sets the metrics recorded by the transport. Defaults to
+// NopMetrics.
+func WithMetrics(m Metrics) Option {
+	return func(o *Options) {
+		o.Metrics = m
+	}
+}
+
+// NopMetrics discards all metrics.
+var NopMetrics Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ClientStarted(string, string, int)                                    {}
+func (nopMetrics) ClientHandled(string, string, codes.Code, time.Duration, int)         {}
+func (nopMetrics) ClientHedged(string, bool)                                            {}
+func (nopMetrics) ServerStarted(string, string, int)                                    {}
+func (nopMetrics) ServerHandled(string, string, string, codes.Code, time.Duration, int) {}
+func (nopMetrics) ServerPending(string, string, int)                                    {}
+func (nopMetrics) ServerDropped(string, string)                                         {}
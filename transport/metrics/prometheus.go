@@ -0,0 +1,204 @@
+// Package metrics provides a Prometheus implementation of transport.Metrics.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/chop-dbhi/nats-rpc/transport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus records transport metrics as Prometheus metrics. It is a
+// prometheus.Collector and must be registered to be exported.
+type Prometheus struct {
+	clientStarted *prometheus.CounterVec
+	clientHandled *prometheus.CounterVec
+	clientLatency *prometheus.HistogramVec
+	clientSize    *prometheus.HistogramVec
+	clientHedged  *prometheus.CounterVec
+
+	serverStarted  *prometheus.CounterVec
+	serverHandled  *prometheus.CounterVec
+	serverLatency  *prometheus.HistogramVec
+	serverSize     *prometheus.HistogramVec
+	serverInFlight *prometheus.GaugeVec
+	serverPending  *prometheus.GaugeVec
+	serverDropped  *prometheus.CounterVec
+}
+
+var _ transport.Metrics = (*Prometheus)(nil)
+
+// sizeBuckets are the payload size buckets in bytes, from 64B to 16MB.
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+
+// NewPrometheus returns Prometheus metrics with names prefixed by the
+// namespace, such as "natsrpc".
+func NewPrometheus(namespace string) *Prometheus {
+	return &Prometheus{
+		clientStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "started_total",
+			Help:      "Total number of publications and requests sent.",
+		}, []string{"kind", "subject"}),
+
+		clientHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "handled_total",
+			Help:      "Total number of publications and requests completed by status code.",
+		}, []string{"kind", "subject", "code"}),
+
+		clientLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "handling_seconds",
+			Help:      "Latency of publications and requests until completion.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"kind", "subject"}),
+
+		clientSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "payload_bytes",
+			Help:      "Size of sent and received payloads.",
+			Buckets:   sizeBuckets,
+		}, []string{"kind", "subject", "direction"}),
+
+		clientHedged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "hedged_total",
+			Help:      "Total number of requests a hedged copy was sent for by whether its reply was used.",
+		}, []string{"subject", "won"}),
+
+		serverStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "started_total",
+			Help:      "Total number of messages handled by subscriptions.",
+		}, []string{"subject", "queue"}),
+
+		serverHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "handled_total",
+			Help:      "Total number of messages handled by subscriptions by status code.",
+		}, []string{"subject", "queue", "code"}),
+
+		serverLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "handling_seconds",
+			Help:      "Latency of handling messages until the reply is sent.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"subject", "queue"}),
+
+		serverSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "payload_bytes",
+			Help:      "Size of received and sent payloads.",
+			Buckets:   sizeBuckets,
+		}, []string{"subject", "queue", "direction"}),
+
+		serverInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "in_flight",
+			Help:      "Number of messages currently being handled.",
+		}, []string{"subject", "queue"}),
+
+		serverPending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "pending",
+			Help:      "Number of messages queued for the workers of a subscription.",
+		}, []string{"subject", "queue"}),
+
+		serverDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "dropped_total",
+			Help:      "Total number of messages dropped because the pending limit was reached.",
+		}, []string{"subject", "queue"}),
+	}
+}
+
+func (p *Prometheus) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		p.clientStarted,
+		p.clientHandled,
+		p.clientLatency,
+		p.clientSize,
+		p.clientHedged,
+		p.serverStarted,
+		p.serverHandled,
+		p.serverLatency,
+		p.serverSize,
+		p.serverInFlight,
+		p.serverPending,
+		p.serverDropped,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (p *Prometheus) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (p *Prometheus) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range p.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (p *Prometheus) ClientStarted(kind, subject string, size int) {
+	p.clientStarted.WithLabelValues(kind, subject).Inc()
+	p.clientSize.WithLabelValues(kind, subject, "sent").Observe(float64(size))
+}
+
+func (p *Prometheus) ClientHandled(kind, subject string, code codes.Code, d time.Duration, size int) {
+	p.clientHandled.WithLabelValues(kind, subject, code.String()).Inc()
+	p.clientLatency.WithLabelValues(kind, subject).Observe(d.Seconds())
+
+	// Publications and failed requests have no reply.
+	if kind == transport.KindRequest && code == codes.OK {
+		p.clientSize.WithLabelValues(kind, subject, "received").Observe(float64(size))
+	}
+}
+
+func (p *Prometheus) ClientHedged(subject string, won bool) {
+	p.clientHedged.WithLabelValues(subject, strconv.FormatBool(won)).Inc()
+}
+
+func (p *Prometheus) ServerStarted(subject, queue string, size int) {
+	p.serverStarted.WithLabelValues(subject, queue).Inc()
+	p.serverInFlight.WithLabelValues(subject, queue).Inc()
+	p.serverSize.WithLabelValues(subject, queue, "received").Observe(float64(size))
+}
+
+func (p *Prometheus) ServerHandled(kind, subject, queue string, code codes.Code, d time.Duration, size int) {
+	p.serverInFlight.WithLabelValues(subject, queue).Dec()
+	p.serverHandled.WithLabelValues(subject, queue, code.String()).Inc()
+	p.serverLatency.WithLabelValues(subject, queue).Observe(d.Seconds())
+
+	// Publications and failed requests are not replied to with a payload.
+	if kind == transport.KindRequest && code == codes.OK {
+		p.serverSize.WithLabelValues(subject, queue, "sent").Observe(float64(size))
+	}
+}
+
+func (p *Prometheus) ServerPending(subject, queue string, n int) {
+	p.serverPending.WithLabelValues(subject, queue).Set(float64(n))
+}
+
+func (p *Prometheus) ServerDropped(subject, queue string) {
+	p.serverDropped.WithLabelValues(subject, queue).Inc()
+}
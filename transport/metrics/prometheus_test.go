@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/chop-dbhi/nats-rpc/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus("natsrpc")
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(p); err != nil {
+		t.Fatal(err)
+	}
+
+	p.ClientStarted(transport.KindRequest, "svc.sum", 10)
+	p.ClientHandled(transport.KindRequest, "svc.sum", codes.OK, time.Millisecond, 20)
+	p.ServerStarted("svc.sum", "svc", 10)
+
+	if n := testutil.ToFloat64(p.serverInFlight.WithLabelValues("svc.sum", "svc")); n != 1 {
+		t.Errorf("expected 1 in-flight message, got %v", n)
+	}
+
+	p.ServerHandled(transport.KindRequest, "svc.sum", "svc", codes.Internal, time.Millisecond, 0)
+
+	if n := testutil.ToFloat64(p.serverInFlight.WithLabelValues("svc.sum", "svc")); n != 0 {
+		t.Errorf("expected no in-flight messages, got %v", n)
+	}
+
+	if n := testutil.ToFloat64(p.clientHandled.WithLabelValues(transport.KindRequest, "svc.sum", "OK")); n != 1 {
+		t.Errorf("expected 1 handled request, got %v", n)
+	}
+
+	if n := testutil.ToFloat64(p.serverHandled.WithLabelValues("svc.sum", "svc", "Internal")); n != 1 {
+		t.Errorf("expected 1 internal error, got %v", n)
+	}
+
+	p.ServerPending("svc.*", "svc", 3)
+	p.ServerDropped("svc.*", "svc")
+
+	if n := testutil.ToFloat64(p.serverPending.WithLabelValues("svc.*", "svc")); n != 3 {
+		t.Errorf("expected 3 pending messages, got %v", n)
+	}
+
+	if n := testutil.ToFloat64(p.serverDropped.WithLabelValues("svc.*", "svc")); n != 1 {
+		t.Errorf("expected 1 dropped message, got %v", n)
+	}
+
+	p.ClientHedged("svc.sum", true)
+
+	if n := testutil.ToFloat64(p.clientHedged.WithLabelValues("svc.sum", "true")); n != 1 {
+		t.Errorf("expected 1 won hedge, got %v", n)
+	}
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrometheusServerSize(t *testing.T) {
+	p := NewPrometheus("natsrpc")
+
+	// Only replies to requests are observed.
+	p.ServerHandled(transport.KindPublish, "svc.event", "", codes.OK, time.Millisecond, 0)
+	p.ServerHandled(transport.KindRequest, "svc.event", "", codes.OK, time.Millisecond, 20)
+
+	var m dto.Metric
+	if err := p.serverSize.WithLabelValues("svc.event", "", "sent").(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := m.GetHistogram().GetSampleCount(); n != 1 {
+		t.Errorf("expected 1 sent payload, got %d", n)
+	}
+}
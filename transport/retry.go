@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+
+	"github.com/golang/protobuf/ptypes"
+	"go.uber.org/zap"
+)
+
+var (
+	// DefaultRetryableCodes are the status codes retried if a retry policy
+	// does not set any.
+	DefaultRetryableCodes = []codes.Code{
+		codes.Unavailable,
+		codes.DeadlineExceeded,
+	}
+
+	// DefaultRetryPolicy is a retry policy suitable for most idempotent requests.
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+)
+
+// RetryPolicy describes how failed requests are retried. Retries are sent
+// with the same message id so subscribers can deduplicate them, but should
+// only be used for idempotent requests. Retries are never made past the
+// deadline of the request context. If the error has a RetryInfo detail,
+// such as when rejected by a rate limit, the retry waits at least the delay
+// it gives.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry, which is multiplied
+	// by Multiplier for every following retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter randomizes each backoff by up to the fraction of it, such as
+	// 0.2 for +/- 20%.
+	Jitter float64
+
+	// AttemptTimeout bounds each attempt, so an unresponsive subscriber
+	// can be retried within the request deadline. Zero disables it.
+	AttemptTimeout time.Duration
+
+	// RetryableCodes are the status codes that are retried. Defaults to
+	// DefaultRetryableCodes.
+	RetryableCodes []codes.Code
+}
+
+// backoff returns the wait before the retry following the attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// retryable returns true if the error has a retryable status code.
+func (p *RetryPolicy) retryable(err error) bool {
+	retryable := p.RetryableCodes
+	if retryable == nil {
+		retryable = DefaultRetryableCodes
+	}
+
+	code := errorStatus(err).Code()
+
+	for _, c := range retryable {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryDelay returns the delay of the RetryInfo detail of the error, if any.
+func retryDelay(err error) time.Duration {
+	for _, d := range errorStatus(err).Details() {
+		info, ok := d.(*errdetails.RetryInfo)
+		if !ok {
+			continue
+		}
+
+		if delay, err := ptypes.Duration(info.RetryDelay); err == nil {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// RequestRetry sets the retry policy of the request. It takes precedence
+// over a policy set for the subject using WithRetryPolicy.
+func RequestRetry(p RetryPolicy) RequestOption {
+	return func(o *RequestOptions) {
+		o.Retry = &p
+	}
+}
+
+// WithRetryPolicy sets the retry policy of requests to the subject, such as
+// the subject of a service method. Requests are not retried by default.
+func WithRetryPolicy(subject string, p RetryPolicy) Option {
+	return func(o *Options) {
+		if o.RetryPolicies == nil {
+			o.RetryPolicies = make(map[string]*RetryPolicy)
+		}
+		o.RetryPolicies[subject] = &p
+	}
+}
+
+// invokeRequest invokes the request once or, if a retry policy is given, as
+// long as the policy allows. The deadline of each attempt is set on the
+// message. Errors are returned as status errors.
+func (c *transport) invokeRequest(ctx context.Context, p *RetryPolicy, m *Message, invoke Invoker) (*Message, error) {
+	for attempt := 1; ; attempt++ {
+		rm, err := c.attemptRequest(ctx, p, m, invoke)
+		if err == nil || p == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return rm, err
+		}
+
+		wait := p.backoff(attempt)
+		if delay := retryDelay(err); delay > wait {
+			wait = delay
+		}
+
+		// Do not wait for a retry that cannot be made in time.
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) <= wait {
+			return rm, err
+		}
+
+		c.logger.Debug("retrying request",
+			zap.String("msg.subject", m.Subject),
+			zap.String("msg.id", m.Id),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", wait),
+			zap.Error(err),
+		)
+
+		t := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return rm, err
+		case <-t.C:
+		}
+	}
+}
+
+func (c *transport) attemptRequest(ctx context.Context, p *RetryPolicy, m *Message, invoke Invoker) (*Message, error) {
+	if p != nil && p.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		defer cancel()
+	}
+
+	m.Deadline = 0
+	if dl, ok := ctx.Deadline(); ok {
+		m.Deadline = uint64(dl.UnixNano())
+	}
+
+	return invoke(ctx, m)
+}
@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/nats-io/go-nats"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+	}
+
+	for i, d := range expected {
+		if b := p.backoff(i + 1); b != d {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, d, b)
+		}
+	}
+
+	p.Jitter = 0.5
+
+	for i := 0; i < 100; i++ {
+		if b := p.backoff(1); b < 50*time.Millisecond || b > 150*time.Millisecond {
+			t.Fatalf("expected jittered backoff within 50%%, got %s", b)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	p := DefaultRetryPolicy
+
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{status.Error(codes.Unavailable, "restarting"), true},
+		{status.Error(codes.DeadlineExceeded, "too slow"), true},
+		{nats.ErrTimeout, true},
+		{nats.ErrNoServers, true},
+		{status.Error(codes.InvalidArgument, "bad"), false},
+		{errors.New("handler failed"), false},
+	}
+
+	for _, test := range tests {
+		if r := p.retryable(test.err); r != test.retryable {
+			t.Errorf("%v: expected retryable %v, got %v", test.err, test.retryable, r)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	err := retryStatus(codes.ResourceExhausted, "slow down", 2*time.Second).Err()
+
+	if d := retryDelay(err); d != 2*time.Second {
+		t.Errorf("expected 2s, got %s", d)
+	}
+
+	if d := retryDelay(status.Error(codes.Unavailable, "restarting")); d != 0 {
+		t.Errorf("expected no delay, got %s", d)
+	}
+}
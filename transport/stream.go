@@ -0,0 +1,694 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/go-nats"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var (
+	// DefaultStreamWindow is the number of DATA frames a stream receives
+	// before the peer has to wait for more credit.
+	DefaultStreamWindow = 64
+
+	// DefaultStreamHeartbeat is the interval at which each side of a stream
+	// tells the peer it is still there.
+	DefaultStreamHeartbeat = 5 * time.Second
+)
+
+// missedHeartbeats is the number of heartbeat intervals without a frame from
+// the peer after which it is considered gone.
+const missedHeartbeats = 3
+
+// WithStreamWindow sets the number of DATA frames a stream receives before
+// the peer has to wait for more credit. Credit is granted as received
+// messages are consumed using Recv, so a slow consumer is not flooded.
+// Defaults to DefaultStreamWindow.
+func WithStreamWindow(n int) Option {
+	return func(o *Options) {
+		o.StreamWindow = n
+	}
+}
+
+// WithStreamHeartbeat sets the interval at which each side of a stream sends
+// a heartbeat to the peer. A stream ends with codes.Unavailable once nothing
+// was received from the peer for three intervals, so Send and Recv do not
+// wait forever for a peer that went away. Zero disables heartbeats. Defaults
+// to DefaultStreamHeartbeat.
+func WithStreamHeartbeat(d time.Duration) Option {
+	return func(o *Options) {
+		o.StreamHeartbeat = d
+	}
+}
+
+// ClientStream is the client side of a stream opened using Transport.Stream.
+// Send and Recv may be called from different goroutines, but neither may
+// be called from multiple goroutines at once.
+type ClientStream interface {
+	// Send sends a message to the server. It blocks until the server has
+	// granted credit. It returns io.EOF if the server ended the stream, in
+	// which case Recv returns the status.
+	Send(pb proto.Message) error
+
+	// CloseSend closes the sending side of the stream. The server receives
+	// io.EOF once it has received all messages.
+	CloseSend() error
+
+	// Recv receives the next message of the stream into pb. It returns io.EOF
+	// once the stream ended successfully, otherwise the status error the
+	// stream ended with.
+	Recv(pb proto.Message) error
+
+	// Context returns the context of the stream. Cancelling the context
+	// passed to Stream cancels the stream on both sides.
+	Context() context.Context
+}
+
+// ServerStream is the server side of a stream. It is obtained from the
+// handler context using ServerStreamFromContext. The stream ends when the
+// handler returns, with the status of the returned error.
+type ServerStream interface {
+	// Send sends a message to the client. It blocks until the client has
+	// granted credit.
+	Send(pb proto.Message) error
+
+	// Recv receives the next message of the client into pb. It returns io.EOF
+	// once the client closed its sending side.
+	Recv(pb proto.Message) error
+
+	// Context returns the context of the stream, which is the handler context.
+	// It is cancelled if the client cancels the stream.
+	Context() context.Context
+}
+
+type serverStreamKey struct{}
+
+// ServerStreamFromContext returns the stream opened by the message being
+// handled, if the message opened one.
+func ServerStreamFromContext(ctx context.Context) (ServerStream, bool) {
+	s, ok := ctx.Value(serverStreamKey{}).(ServerStream)
+	return s, ok
+}
+
+// stream is the state shared by both sides of a stream. Frames are received
+// on the inbox of each side and sent to the inbox of the peer.
+type stream struct {
+	c          *transport
+	ctx        context.Context
+	cancel     context.CancelFunc
+	id         string
+	inbox      string
+	sub        brokerSubscription
+	codec      Codec
+	compressor Compressor
+	window     int
+	heartbeat  time.Duration
+	client     bool
+
+	// frames are the received DATA and END frames. signal is notified when
+	// credit is granted or the peer becomes known.
+	frames chan *Message
+	signal chan struct{}
+
+	mux      sync.Mutex
+	peer     string
+	credit   int
+	sendSeq  uint64
+	recvSeq  uint64
+	sendDone bool
+	err      error
+
+	// received is when a frame was last received from the peer.
+	received time.Time
+
+	// ended is set once the client received the END frame of the server
+	// and result is the status it carried.
+	ended  bool
+	result error
+
+	// consumed is the number of messages received since credit was last
+	// granted and recvErr is the error returned by Recv once the stream
+	// ended. Both are only used by Recv.
+	consumed int
+	recvErr  error
+}
+
+func (c *transport) newStream(ctx context.Context, id string, codec Codec, compressor Compressor, client bool) (*stream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	window := c.opts.StreamWindow
+	if window < 1 {
+		window = DefaultStreamWindow
+	}
+
+	s := &stream{
+		c:          c,
+		ctx:        ctx,
+		cancel:     cancel,
+		id:         id,
+		inbox:      nats.NewInbox(),
+		codec:      codec,
+		compressor: compressor,
+		window:     window,
+		heartbeat:  c.opts.StreamHeartbeat,
+		client:     client,
+		frames:     make(chan *Message, window+1),
+		signal:     make(chan struct{}, 1),
+		received:   time.Now(),
+	}
+
+	var err error
+	s.sub, err = c.conn.Subscribe(s.inbox, s.handle)
+	if err != nil {
+		cancel()
+		return nil, statusError(err)
+	}
+
+	return s, nil
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+// handle handles a frame received from the peer.
+func (s *stream) handle(nm *nats.Msg) {
+	m, err := s.c.unwrap(nm)
+	if err == nil && m.Chunks > 0 {
+		m, err = s.c.reassemble(s.ctx, m)
+	}
+	if err != nil {
+		s.fail(statusError(err))
+		return
+	}
+
+	s.mux.Lock()
+
+	s.received = time.Now()
+
+	// The peer is known once the first frame is received from it.
+	if s.peer == "" {
+		s.peer = m.Reply
+	}
+
+	switch m.Frame {
+	case Frame_CREDIT:
+		s.credit += int(m.Credit)
+		s.mux.Unlock()
+		s.notify()
+		return
+
+	case Frame_CANCEL:
+		s.mux.Unlock()
+		s.fail(status.Error(codes.Canceled, "stream cancelled by peer"))
+		return
+
+	case Frame_DATA:
+		s.recvSeq++
+
+		// The peer must send in order and within its credit, so this is
+		// a bug or frames were dropped.
+		if m.Sequence != s.recvSeq {
+			s.mux.Unlock()
+			s.fail(status.Errorf(codes.DataLoss, "expected stream message %d, got %d", s.recvSeq, m.Sequence))
+			return
+		}
+
+	case Frame_END:
+		// The server ended the stream, the client may not send any more.
+		if s.client {
+			s.ended = true
+			if m.Status != nil {
+				s.result = status.FromProto(m.Status).Err()
+			}
+		}
+
+	default:
+		s.mux.Unlock()
+		s.fail(status.Errorf(codes.Internal, "unexpected stream frame %s", m.Frame))
+		return
+	}
+
+	s.mux.Unlock()
+	s.notify()
+
+	select {
+	case s.frames <- m:
+	default:
+		s.fail(status.Error(codes.ResourceExhausted, "stream peer exceeded its credit"))
+		return
+	}
+
+	// Received frames are still returned by Recv.
+	if m.Frame == Frame_END && s.client {
+		s.cancel()
+	}
+}
+
+// keepalive sends heartbeats to the peer until the stream is done and fails
+// the stream once nothing was received from the peer for too long.
+// Heartbeats are CREDIT frames without credit, which peers not expecting them
+// ignore.
+func (s *stream) keepalive() {
+	if s.heartbeat <= 0 {
+		return
+	}
+
+	t := time.NewTicker(s.heartbeat)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			return
+		}
+
+		s.mux.Lock()
+		idle := time.Since(s.received)
+
+		// Failures to publish are logged by publish, a peer that cannot
+		// be reached is noticed by its own missing heartbeats.
+		if s.peer != "" {
+			s.publish(&Message{
+				Id:        s.id,
+				Timestamp: uint64(time.Now().UnixNano()),
+				Frame:     Frame_CREDIT,
+			})
+		}
+		s.mux.Unlock()
+
+		if idle > missedHeartbeats*s.heartbeat {
+			s.fail(status.Errorf(codes.Unavailable, "no frame received from stream peer for %s", idle.Round(time.Millisecond)))
+			return
+		}
+	}
+}
+
+func (s *stream) notify() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// fail ends the stream with the error, unless it already failed.
+func (s *stream) fail(err error) {
+	s.mux.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mux.Unlock()
+
+	s.cancel()
+}
+
+// error returns the error the stream failed with or the error of the context.
+func (s *stream) error() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	return statusError(s.ctx.Err())
+}
+
+func (s *stream) Send(pb proto.Message) error {
+	m, err := s.c.wrap(pb, s.codec)
+	if err != nil {
+		return err
+	}
+
+	if err := compress(m, s.compressor, s.c.opts.CompressionThreshold); err != nil {
+		return err
+	}
+
+	m.Frame = Frame_DATA
+
+	return s.send(m)
+}
+
+func (s *stream) Recv(pb proto.Message) error {
+	if s.recvErr != nil {
+		return s.recvErr
+	}
+
+	var m *Message
+
+	select {
+	case m = <-s.frames:
+	case <-s.ctx.Done():
+		// Frames received before the context was cancelled, such as the
+		// END frame of the server, are still returned.
+		select {
+		case m = <-s.frames:
+		default:
+			s.recvErr = s.error()
+			return s.recvErr
+		}
+	}
+
+	if m.Frame == Frame_END {
+		s.recvErr = io.EOF
+		if m.Status != nil {
+			if err := status.FromProto(m.Status).Err(); err != nil {
+				s.recvErr = err
+			}
+		}
+		return s.recvErr
+	}
+
+	if err := s.grant(); err != nil {
+		return err
+	}
+
+	return m.Decode(pb)
+}
+
+// grant grants the peer credit for the consumed messages once half of the
+// window has been consumed.
+func (s *stream) grant() error {
+	s.consumed++
+	if s.consumed*2 < s.window {
+		return nil
+	}
+
+	// Frames received before the stream was done are still returned, but
+	// the peer no longer needs credit.
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	m := &Message{
+		Id:        s.id,
+		Timestamp: uint64(time.Now().UnixNano()),
+		Frame:     Frame_CREDIT,
+		Credit:    uint32(s.consumed),
+	}
+
+	s.consumed = 0
+
+	return s.send(m)
+}
+
+// send sends the frame to the peer. DATA frames wait for credit, all frames
+// wait for the peer to be known.
+func (s *stream) send(m *Message) error {
+	data := m.Frame == Frame_DATA
+
+	for {
+		s.mux.Lock()
+
+		if s.err != nil {
+			s.mux.Unlock()
+			return s.err
+		}
+
+		// The stream is done without failing, such as when the caller
+		// cancelled it, so the peer no longer expects frames.
+		if err := s.ctx.Err(); err != nil {
+			s.mux.Unlock()
+			return statusError(err)
+		}
+
+		if s.sendDone && (data || m.Frame == Frame_END) {
+			s.mux.Unlock()
+			return status.Error(codes.FailedPrecondition, "send on closed stream")
+		}
+
+		if s.peer != "" && (!data || s.credit > 0) {
+			if data {
+				s.credit--
+				s.sendSeq++
+				m.Sequence = s.sendSeq
+			}
+
+			if m.Frame == Frame_END {
+				s.sendDone = true
+			}
+
+			// Frames are published while holding the lock so they are
+			// sent in order.
+			err := s.publish(m)
+			s.mux.Unlock()
+			return err
+		}
+
+		s.mux.Unlock()
+
+		select {
+		case <-s.signal:
+		case <-s.ctx.Done():
+			return s.error()
+		}
+	}
+}
+
+func (s *stream) publish(m *Message) error {
+	m.Cause = s.id
+	m.Subject = s.peer
+
+	mb, err := s.c.encode(s.c.ctx, m)
+	if err != nil {
+		return err
+	}
+
+	if err := s.c.conn.PublishRequest(s.peer, s.inbox, mb); err != nil {
+		s.c.logger.Error("failed to publish stream message",
+			zap.String("msg.subject", s.peer),
+			zap.String("msg.cause", s.id),
+			zap.Error(err),
+		)
+		return statusError(err)
+	}
+
+	return nil
+}
+
+func (c *transport) Stream(ctx context.Context, sub string, req proto.Message, opts ...RequestOption) (ClientStream, error) {
+	// Streams are long-lived, so the request timeout only applies if it is
+	// set explicitly.
+	reqOpts := &RequestOptions{
+		Codec:      c.opts.Codec,
+		Compressor: c.opts.Compressor,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(reqOpts)
+	}
+
+	var cancel context.CancelFunc
+	if reqOpts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, reqOpts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	m, err := c.wrap(req, reqOpts.Codec)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if err := compress(m, reqOpts.Compressor, c.opts.CompressionThreshold); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	m.Subject = sub
+	m.Cause = reqOpts.Cause
+	m.Metadata = reqOpts.Metadata
+	m.Frame = Frame_OPEN
+
+	if dl, ok := ctx.Deadline(); ok {
+		m.Deadline = uint64(dl.UnixNano())
+	}
+
+	ctx, span := c.startClientSpan(ctx, m, trace.SpanKindClient)
+
+	// Subscribe before the stream is opened so no frames are missed.
+	st, err := c.newStream(ctx, m.Id, reqOpts.Codec, reqOpts.Compressor, true)
+	if err != nil {
+		endSpan(span, err)
+		cancel()
+		return nil, err
+	}
+
+	// The stream context is derived from ctx, so cancelling ctx once the
+	// stream finished only releases its resources.
+	st.cancel = cancelBoth(st.cancel, cancel)
+
+	s := &clientStream{
+		stream:  st,
+		subject: sub,
+		span:    span,
+		start:   time.Now(),
+	}
+
+	m.Reply = st.inbox
+	m.Credit = uint32(st.window)
+
+	c.opts.Metrics.ClientStarted(KindStream, sub, len(m.Payload))
+
+	go s.finish()
+	go s.keepalive()
+
+	invoke := chainInvoker(
+		joinClientInterceptors(c.opts.ClientInterceptors, reqOpts.Interceptors),
+		c.publish,
+	)
+
+	if _, err := invoke(s.ctx, m); err != nil {
+		err = statusError(err)
+		s.fail(err)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// cancelBoth returns a cancel function calling both.
+func cancelBoth(a, b context.CancelFunc) context.CancelFunc {
+	return func() {
+		a()
+		b()
+	}
+}
+
+type clientStream struct {
+	*stream
+
+	subject string
+	span    trace.Span
+	start   time.Time
+}
+
+func (s *clientStream) Send(pb proto.Message) error {
+	err := s.stream.Send(pb)
+
+	// Like gRPC, the status of a stream ended by the server is returned
+	// by Recv.
+	if err != nil && s.isEnded() {
+		return io.EOF
+	}
+
+	return err
+}
+
+func (s *clientStream) CloseSend() error {
+	m := &Message{
+		Id:        s.id,
+		Timestamp: uint64(time.Now().UnixNano()),
+		Frame:     Frame_END,
+	}
+
+	// The status of a stream ended by the server is returned by Recv.
+	if err := s.send(m); err != nil && !s.isEnded() {
+		return err
+	}
+
+	return nil
+}
+
+func (s *clientStream) isEnded() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.ended
+}
+
+// finish waits for the stream to end and cleans up. If the server did not
+// end the stream, it is told to cancel it.
+func (s *clientStream) finish() {
+	<-s.ctx.Done()
+
+	s.sub.Unsubscribe()
+
+	s.mux.Lock()
+	ended, result, peer := s.ended, s.result, s.peer
+	s.mux.Unlock()
+
+	if !ended {
+		result = s.error()
+
+		if peer != "" {
+			m := &Message{
+				Id:        s.id,
+				Cause:     s.id,
+				Subject:   peer,
+				Timestamp: uint64(time.Now().UnixNano()),
+				Frame:     Frame_CANCEL,
+			}
+
+			if mb, err := proto.Marshal(m); err == nil {
+				s.c.conn.Publish(peer, mb)
+			}
+		}
+	}
+
+	endSpan(s.span, result)
+	s.c.opts.Metrics.ClientHandled(KindStream, s.subject, errorStatus(result).Code(), time.Since(s.start), 0)
+}
+
+// newServerStream returns the stream opened by the message and grants the
+// client its initial credit.
+func (c *transport) newServerStream(ctx context.Context, subOpts *SubscribeOptions, msg *Message) (*stream, error) {
+	s, err := c.newStream(ctx, msg.Id, c.replyCodec(msg), c.replyCompressor(subOpts, msg), false)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ctx = context.WithValue(s.ctx, serverStreamKey{}, ServerStream(s))
+	s.peer = msg.Reply
+	s.credit = int(msg.Credit)
+
+	m := &Message{
+		Id:        s.id,
+		Timestamp: uint64(time.Now().UnixNano()),
+		Frame:     Frame_CREDIT,
+		Credit:    uint32(s.window),
+	}
+
+	if err := s.send(m); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	go s.keepalive()
+
+	return s, nil
+}
+
+// end ends the stream with the status of the error returned by the handler.
+func (s *stream) end(err error) error {
+	defer s.close()
+
+	m, werr := s.c.wrap(nil, s.codec)
+	if werr != nil {
+		return werr
+	}
+
+	m.Frame = Frame_END
+	m.Status = errorStatus(err).Proto()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.publish(m)
+}
+
+func (s *stream) close() {
+	s.sub.Unsubscribe()
+	s.cancel()
+}
@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// frameBroker counts the DATA frames published through the broker.
+type frameBroker struct {
+	broker
+	data int32
+}
+
+func (b *frameBroker) PublishRequest(subject, reply string, data []byte) error {
+	var m Message
+	if err := proto.Unmarshal(data, &m); err == nil && m.Frame == Frame_DATA {
+		atomic.AddInt32(&b.data, 1)
+	}
+	return b.broker.PublishRequest(subject, reply, data)
+}
+
+func TestStreamSendAfterCancel(t *testing.T) {
+	tp := NewMemory().(*transport)
+	defer tp.Close()
+
+	b := &frameBroker{broker: tp.conn}
+	tp.conn = b
+
+	hdlr := func(ctx context.Context, _ *Message) (proto.Message, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := tp.Stream(ctx, "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waits for the peer to be known.
+	if err := stream.Send(&Message{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	select {
+	case <-stream.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to be cancelled")
+	}
+
+	n := atomic.LoadInt32(&b.data)
+
+	if err := stream.Send(&Message{}); status.Code(err) != codes.Canceled {
+		t.Errorf("expected send after cancel to fail, got %v", err)
+	}
+
+	if m := atomic.LoadInt32(&b.data); m != n {
+		t.Errorf("expected no frame to be published after cancel, got %d", m-n)
+	}
+}
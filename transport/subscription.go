@@ -0,0 +1,214 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// DefaultGracePeriod is how long Drain waits for in-flight handlers by
+// default.
+const DefaultGracePeriod = 10 * time.Second
+
+// SubscribeGracePeriod sets how long Drain waits for in-flight handlers
+// before cancelling their contexts. Zero waits until the context passed to
+// Drain is done.
+func SubscribeGracePeriod(d time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.GracePeriod = d
+	}
+}
+
+// DefaultPendingLimit is the default number of messages a subscription
+// with concurrent workers queues before rejecting messages.
+const DefaultPendingLimit = 1024
+
+// SubscribeConcurrency sets the number of messages handled concurrently by
+// a pool of workers. By default messages are handled one at a time in the
+// order they are received.
+func SubscribeConcurrency(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Concurrency = n
+	}
+}
+
+// SubscribePendingLimit sets the number of messages queued for the workers
+// of a subscription, see SubscribeConcurrency. Once the limit is reached,
+// requests are rejected with codes.ResourceExhausted. Defaults to
+// DefaultPendingLimit.
+func SubscribePendingLimit(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.PendingLimit = n
+	}
+}
+
+// Subscription is a subscription created by Subscribe.
+type Subscription interface {
+	// Unsubscribe removes the subscription. In-flight handlers keep
+	// running.
+	Unsubscribe() error
+
+	// Drain removes the subscription, handles the messages already
+	// received from the broker and waits for in-flight handlers to
+	// return, for up to the grace period or until the context is done.
+	// The contexts of handlers still running are then cancelled and the
+	// context error is returned. Requests received once the subscription
+	// is removed are rejected with codes.Unavailable so they can be
+	// retried elsewhere.
+	Drain(ctx context.Context) error
+}
+
+// subscription tracks the in-flight handlers of a broker subscription so it
+// can be drained.
+type subscription struct {
+	sub   brokerSubscription
+	grace time.Duration
+
+	// ctx is the parent of handler contexts.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mux      sync.Mutex
+	inflight int
+	removing bool
+	draining bool
+	idle     chan struct{}
+
+	// queue holds messages for the workers, if any, until quit is closed.
+	queue    chan *nats.Msg
+	pending  func(int)
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+func newSubscription(parent context.Context, grace time.Duration) *subscription {
+	ctx, cancel := context.WithCancel(parent)
+
+	return &subscription{
+		grace:  grace,
+		ctx:    ctx,
+		cancel: cancel,
+		idle:   make(chan struct{}),
+		quit:   make(chan struct{}),
+	}
+}
+
+// begin registers an in-flight handler. It returns false if the
+// subscription is draining.
+func (s *subscription) begin() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.draining {
+		return false
+	}
+
+	s.inflight++
+	return true
+}
+
+// end unregisters an in-flight handler.
+func (s *subscription) end() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.inflight--
+	if s.draining && s.inflight == 0 {
+		close(s.idle)
+	}
+}
+
+// startWorkers starts n workers handling queued messages. The number of
+// queued messages is reported to pending when it changes.
+func (s *subscription) startWorkers(n, limit int, handle nats.MsgHandler, pending func(int)) {
+	s.queue = make(chan *nats.Msg, limit)
+	s.pending = pending
+
+	for i := 0; i < n; i++ {
+		go s.work(handle)
+	}
+}
+
+func (s *subscription) work(handle nats.MsgHandler) {
+	for {
+		select {
+		case nmsg := <-s.queue:
+			s.pending(len(s.queue))
+			handle(nmsg)
+			s.end()
+
+		case <-s.quit:
+			// Queued messages are dropped.
+			for {
+				select {
+				case <-s.queue:
+					s.end()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// enqueue queues a message for the workers. It returns false if the
+// pending limit is reached.
+func (s *subscription) enqueue(nmsg *nats.Msg) bool {
+	select {
+	case s.queue <- nmsg:
+		s.pending(len(s.queue))
+		return true
+	default:
+		return false
+	}
+}
+
+// stop stops the workers.
+func (s *subscription) stop() {
+	s.quitOnce.Do(func() {
+		close(s.quit)
+	})
+}
+
+func (s *subscription) Unsubscribe() error {
+	defer s.stop()
+	return s.sub.Unsubscribe()
+}
+
+func (s *subscription) Drain(ctx context.Context) error {
+	if s.grace > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.grace)
+		defer cancel()
+	}
+
+	defer s.cancel()
+	defer s.stop()
+
+	s.mux.Lock()
+	first := !s.removing
+	s.removing = true
+	s.mux.Unlock()
+
+	if first {
+		// The subscription may already be removed, such as by closing the
+		// transport, which is fine since only the handlers are waited for.
+		s.sub.Drain(ctx)
+
+		s.mux.Lock()
+		s.draining = true
+		if s.inflight == 0 {
+			close(s.idle)
+		}
+		s.mux.Unlock()
+	}
+
+	select {
+	case <-s.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
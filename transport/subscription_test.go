@@ -0,0 +1,249 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestSubscriptionDrain(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	started := make(chan struct{})
+
+	sub, err := tp.Subscribe("_transport", func(ctx context.Context, msg *Message) (proto.Message, error) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return &Message{Id: "done"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		var rep Message
+		_, err := tp.Request(context.Background(), "_transport", nil, &rep)
+		errs <- err
+	}()
+
+	<-started
+
+	// The in-flight request is waited for.
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("expected in-flight request to succeed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected in-flight request to be replied to")
+	}
+}
+
+func TestSubscriptionDrainPending(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handled := make(chan struct{}, 2)
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		started <- struct{}{}
+		<-release
+		handled <- struct{}{}
+		return nil, nil
+	}
+
+	sub, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	// Queued behind the first message.
+	if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sub.Drain(context.Background())
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// Both messages were received before draining, so both are handled.
+	if n := len(handled); n != 2 {
+		t.Errorf("expected 2 handled messages, got %d", n)
+	}
+}
+
+func TestSubscriptionDrainGracePeriod(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	sub, err := tp.Subscribe("_transport", hdlr, SubscribeGracePeriod(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	if err := sub.Drain(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected handler to be cancelled after the grace period")
+	}
+}
+
+func TestSubscribeConcurrency(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		started <- struct{}{}
+		<-release
+		return nil, nil
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr, SubscribeConcurrency(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// All messages are handled at the same time.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("expected 3 concurrent handlers, got %d", i)
+		}
+	}
+
+	close(release)
+}
+
+func TestSubscribePendingLimit(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	defer close(release)
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		started <- struct{}{}
+		<-release
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr, SubscribeConcurrency(2), SubscribePendingLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Occupy both workers.
+	for i := 0; i < 2; i++ {
+		if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+			t.Fatal(err)
+		}
+		<-started
+	}
+
+	// Fill the queue.
+	if _, err := tp.Publish(context.Background(), "_transport", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tp.Request(context.Background(), "_transport", nil, nil, RequestTimeout(time.Second))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected resource exhausted, got %v", err)
+	}
+}
+
+func TestSubscriptionDrainStream(t *testing.T) {
+	tp := NewMemory()
+	defer tp.Close()
+
+	started := make(chan struct{})
+
+	sub, err := tp.Subscribe("_transport", func(ctx context.Context, msg *Message) (proto.Message, error) {
+		stream, _ := ServerStreamFromContext(ctx)
+
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+
+		return nil, stream.Send(&Message{Id: "done"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := tp.Stream(context.Background(), "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	// The stream handled in its own goroutine is waited for.
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var rep Message
+	if err := stream.Recv(&rep); err != nil {
+		t.Fatalf("expected in-flight stream to complete, got %v", err)
+	}
+
+	if rep.Id != "done" {
+		t.Errorf("expected reply, got %q", rep.Id)
+	}
+}
@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/chop-dbhi/nats-rpc/transport"
+
+// WithTracerProvider sets the provider of the tracer used to create spans
+// for publications, requests and handled messages. Defaults to the global
+// tracer provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *Options) {
+		o.TracerProvider = tp
+	}
+}
+
+// WithPropagator sets the propagator used to inject the trace context into
+// messages and extract it from them. Defaults to the W3C trace context.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(o *Options) {
+		o.Propagator = p
+	}
+}
+
+func (c *transport) tracer() trace.Tracer {
+	tp := c.opts.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(tracerName)
+}
+
+// spanAttributes returns the attributes describing the message.
+func spanAttributes(m *Message) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "nats"),
+		attribute.String("messaging.destination.name", m.Subject),
+		attribute.String("messaging.message.id", m.Id),
+	}
+
+	if m.Queue != "" {
+		attrs = append(attrs, attribute.String("messaging.nats.queue", m.Queue))
+	}
+
+	return attrs
+}
+
+// startClientSpan starts a span for an outgoing message and injects its
+// trace context into the message.
+func (c *transport) startClientSpan(ctx context.Context, m *Message, kind trace.SpanKind) (context.Context, trace.Span) {
+	ctx, span := c.tracer().Start(ctx, m.Subject,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(spanAttributes(m)...),
+	)
+
+	if m.TraceContext == nil {
+		m.TraceContext = make(map[string]string)
+	}
+
+	c.opts.Propagator.Inject(ctx, propagation.MapCarrier(m.TraceContext))
+
+	return ctx, span
+}
+
+// startServerSpan extracts the trace context of an incoming message and
+// starts a span continuing it.
+func (c *transport) startServerSpan(ctx context.Context, m *Message) (context.Context, trace.Span) {
+	ctx = c.opts.Propagator.Extract(ctx, propagation.MapCarrier(m.TraceContext))
+
+	kind := trace.SpanKindServer
+	if m.Reply == "" {
+		kind = trace.SpanKindConsumer
+	}
+
+	return c.tracer().Start(ctx, m.Subject,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(spanAttributes(m)...),
+	)
+}
+
+// endSpan records the status code of the error on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	sts := errorStatus(err)
+
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(sts.Code())))
+
+	if sts.Code() != codes.OK {
+		span.SetStatus(otelcodes.Error, sts.Message())
+	}
+
+	span.End()
+}
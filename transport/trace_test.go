@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/golang/protobuf/proto"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracePropagation(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+
+	c := &transport{
+		opts: &Options{
+			TracerProvider: tp,
+			Propagator:     propagation.TraceContext{},
+		},
+	}
+
+	msg := &Message{
+		Id:      "abc",
+		Subject: "_transport",
+		Reply:   "_inbox",
+	}
+
+	_, cspan := c.startClientSpan(context.Background(), msg, trace.SpanKindClient)
+
+	if msg.TraceContext["traceparent"] == "" {
+		t.Fatal("expected traceparent to be injected")
+	}
+
+	_, sspan := c.startServerSpan(context.Background(), msg)
+	endSpan(sspan, errors.New("handler failed"))
+	endSpan(cspan, nil)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	server, client := spans[0], spans[1]
+
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("expected server span, got %s", server.SpanKind())
+	}
+
+	if server.Parent().SpanID() != client.SpanContext().SpanID() {
+		t.Errorf("expected server span to continue the client span")
+	}
+
+	if server.SpanContext().TraceID() != client.SpanContext().TraceID() {
+		t.Errorf("expected spans to share the trace id")
+	}
+
+	if server.Status().Description != "handler failed" {
+		t.Errorf("expected error status, got %q", server.Status().Description)
+	}
+}
+
+func TestHandlerPanicSpan(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+
+	tp := NewMemory(WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))))
+	defer tp.Close()
+
+	hdlr := func(ctx context.Context, msg *Message) (proto.Message, error) {
+		panic("boom")
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tp.Request(context.Background(), "_transport", nil, nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The stream of a panicking handler is ended.
+	stream, err := tp.Stream(context.Background(), "_transport", nil, RequestTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep Message
+	if err := stream.Recv(&rep); status.Code(err) != codes.Internal {
+		t.Errorf("expected internal, got %v", err)
+	}
+
+	var server int
+	for _, span := range rec.Ended() {
+		if span.SpanKind() == trace.SpanKindServer {
+			server++
+			if span.Status().Description != "boom" {
+				t.Errorf("expected panic status, got %q", span.Status().Description)
+			}
+		}
+	}
+
+	if server != 2 {
+		t.Errorf("expected 2 ended server spans, got %d", server)
+	}
+}
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -12,6 +13,8 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/nats-io/go-nats"
 	"github.com/nats-io/nuid"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -19,9 +22,106 @@ var (
 	DefaultRequestTimeout = 2 * time.Second
 )
 
+// Options are options for a transport.
+type Options struct {
+	ClientInterceptors []ClientInterceptor
+	ServerInterceptors []ServerInterceptor
+	Codec              Codec
+
+	// Compressor is the compressor applied to payloads of at least
+	// CompressionThreshold bytes. Nil disables compression.
+	Compressor           Compressor
+	CompressionThreshold int
+
+	// MaxMessageSize is the maximum size in bytes of a message sent or
+	// received in chunks. ChunkTimeout bounds how long chunks are served
+	// and fetched.
+	MaxMessageSize int
+	ChunkTimeout   time.Duration
+
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+	Metrics        Metrics
+
+	// RetryPolicies are the retry policies of requests keyed by subject.
+	RetryPolicies map[string]*RetryPolicy
+
+	// CircuitBreaker is the circuit breaker of requests, if any.
+	CircuitBreaker *CircuitBreaker
+
+	// StreamWindow is the credit granted by each side of a stream and
+	// StreamHeartbeat the interval of its heartbeats.
+	StreamWindow    int
+	StreamHeartbeat time.Duration
+}
+
+type Option func(*Options)
+
+// WithMaxMessageSize sets the maximum size in bytes of a message exceeding
+// the max payload of the connection. Such messages are sent in chunks and
+// reassembled by the receiver. Larger messages are rejected with a
+// ResourceExhausted status. Defaults to DefaultMaxMessageSize.
+func WithMaxMessageSize(n int) Option {
+	return func(o *Options) {
+		o.MaxMessageSize = n
+	}
+}
+
+// WithChunkTimeout sets how long the chunks of a message are served by the
+// sender and how long the receiver waits for them. Defaults to
+// DefaultChunkTimeout.
+func WithChunkTimeout(t time.Duration) Option {
+	return func(o *Options) {
+		o.ChunkTimeout = t
+	}
+}
+
+// WithCompressor sets the compressor applied to payloads that are at least
+// the compression threshold in size. Compression is disabled by default.
+func WithCompressor(c Compressor) Option {
+	return func(o *Options) {
+		o.Compressor = c
+	}
+}
+
+// WithCompressionThreshold sets the payload size in bytes below which
+// compression is skipped. Defaults to DefaultCompressionThreshold.
+func WithCompressionThreshold(n int) Option {
+	return func(o *Options) {
+		o.CompressionThreshold = n
+	}
+}
+
+// WithCodec sets the codec used to encode payloads. Defaults to ProtoCodec.
+func WithCodec(c Codec) Option {
+	return func(o *Options) {
+		o.Codec = c
+	}
+}
+
+// WithClientInterceptors adds interceptors applied to all publications and
+// requests made by the transport.
+func WithClientInterceptors(interceptors ...ClientInterceptor) Option {
+	return func(o *Options) {
+		o.ClientInterceptors = append(o.ClientInterceptors, interceptors...)
+	}
+}
+
+// WithServerInterceptors adds interceptors applied to all subscriptions
+// made by the transport.
+func WithServerInterceptors(interceptors ...ServerInterceptor) Option {
+	return func(o *Options) {
+		o.ServerInterceptors = append(o.ServerInterceptors, interceptors...)
+	}
+}
+
 // PublishOptions are options for a publication.
 type PublishOptions struct {
-	Cause string
+	Cause        string
+	Metadata     Metadata
+	Interceptors []ClientInterceptor
+	Codec        Codec
+	Compressor   Compressor
 }
 
 type PublishOption func(*PublishOptions)
@@ -33,10 +133,49 @@ func PublishCause(s string) PublishOption {
 	}
 }
 
+// PublishMetadata adds metadata to the publication. It may be used multiple
+// times, later values for the same key take precedence.
+func PublishMetadata(md Metadata) PublishOption {
+	return func(o *PublishOptions) {
+		o.Metadata = o.Metadata.merge(md)
+	}
+}
+
+// PublishCodec sets the codec used to encode the payload of the publication.
+func PublishCodec(c Codec) PublishOption {
+	return func(o *PublishOptions) {
+		o.Codec = c
+	}
+}
+
+// PublishCompressor sets the compressor applied to the payload of the
+// publication if it is at least the compression threshold in size.
+func PublishCompressor(c Compressor) PublishOption {
+	return func(o *PublishOptions) {
+		o.Compressor = c
+	}
+}
+
+// PublishInterceptors adds interceptors applied to the publication after
+// those of the transport.
+func PublishInterceptors(interceptors ...ClientInterceptor) PublishOption {
+	return func(o *PublishOptions) {
+		o.Interceptors = append(o.Interceptors, interceptors...)
+	}
+}
+
 // RequestOptions are options for a publication.
 type RequestOptions struct {
-	Cause   string
-	Timeout time.Duration
+	Cause        string
+	Timeout      time.Duration
+	Metadata     Metadata
+	Interceptors []ClientInterceptor
+	Codec        Codec
+	Compressor   Compressor
+	Retry        *RetryPolicy
+	HedgeDelay   time.Duration
+	Count        int
+	Quorum       int
 }
 
 type RequestOption func(*RequestOptions)
@@ -55,9 +194,52 @@ func RequestCause(s string) RequestOption {
 	}
 }
 
+// RequestMetadata adds metadata to the request. It may be used multiple
+// times, later values for the same key take precedence.
+func RequestMetadata(md Metadata) RequestOption {
+	return func(o *RequestOptions) {
+		o.Metadata = o.Metadata.merge(md)
+	}
+}
+
+// RequestCodec sets the codec used to encode the payload of the request.
+// The reply is encoded by the subscriber using the same codec, if it is
+// registered there.
+func RequestCodec(c Codec) RequestOption {
+	return func(o *RequestOptions) {
+		o.Codec = c
+	}
+}
+
+// RequestCompressor sets the compressor applied to the payload of the
+// request if it is at least the compression threshold in size. The reply is
+// compressed by the subscriber using the same compressor, if it is
+// registered there.
+func RequestCompressor(c Compressor) RequestOption {
+	return func(o *RequestOptions) {
+		o.Compressor = c
+	}
+}
+
+// RequestInterceptors adds interceptors applied to the request after those
+// of the transport.
+func RequestInterceptors(interceptors ...ClientInterceptor) RequestOption {
+	return func(o *RequestOptions) {
+		o.Interceptors = append(o.Interceptors, interceptors...)
+	}
+}
+
 // SubscribeOptions are options for a subscriber.
 type SubscribeOptions struct {
-	Queue string
+	Queue        string
+	Context      context.Context
+	Interceptors []ServerInterceptor
+	Compressor   Compressor
+	GracePeriod  time.Duration
+	Concurrency  int
+	PendingLimit int
+	RateLimits   []RateLimit
+	LoadShedding *LoadShedding
 }
 
 type SubscribeOption func(*SubscribeOptions)
@@ -69,33 +251,106 @@ func SubscribeQueue(q string) SubscribeOption {
 	}
 }
 
-// Decode decodes the message payload into a proto message.
+// SubscribeContext sets the context handler contexts are derived from.
+// Cancelling it cancels all in-flight handlers. Defaults to a context that
+// is cancelled when the transport is closed.
+func SubscribeContext(ctx context.Context) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Context = ctx
+	}
+}
+
+// SubscribeCompressor sets the compressor applied to reply payloads. By
+// default replies are compressed using the compressor of the request or,
+// if the request was not compressed, the transport compressor.
+func SubscribeCompressor(c Compressor) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Compressor = c
+	}
+}
+
+// SubscribeInterceptors adds interceptors applied to the subscription after
+// those of the transport.
+func SubscribeInterceptors(interceptors ...ServerInterceptor) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Interceptors = append(o.Interceptors, interceptors...)
+	}
+}
+
+// Decode decodes the message payload into a proto message using the codec
+// identified by the content type of the message. The payload is decompressed
+// first if a content encoding is set.
 func (m *Message) Decode(pb proto.Message) error {
-	return proto.Unmarshal(m.Payload, pb)
+	c, err := codecFor(m.ContentType)
+	if err != nil {
+		return err
+	}
+
+	payload := m.Payload
+
+	comp, err := compressorFor(m.ContentEncoding)
+	if err != nil {
+		return err
+	}
+
+	if comp != nil {
+		payload, err = comp.Decompress(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.Unmarshal(payload, pb)
 }
 
 // Handler is the handler used by a subscriber. The return value may be nil if
 // no output is yielded. If this is a request, the reply will be sent automatically
 // with the reply value or an error if one occurred. If a reply is not expected
 // and error occurs, it will be logged. The error can be inspected using status.FromError.
-type Handler func(msg *Message) (proto.Message, error)
+// The context is derived per message. It carries the message itself, see
+// MessageFromContext, and the deadline of the request, if one was set.
+type Handler func(ctx context.Context, msg *Message) (proto.Message, error)
 
 // Transport describes the interface
 type Transport interface {
 	// Publish publishes a message asynchronously to the specified subject.
 	// The wrapped message is returned or an error. The error would only be due to
-	// a connection issue, but does not reflect any consumer error.
-	Publish(sub string, msg proto.Message, opts ...PublishOption) (*Message, error)
+	// a connection issue, but does not reflect any consumer error. The trace
+	// context of the context is sent with the message.
+	Publish(ctx context.Context, sub string, msg proto.Message, opts ...PublishOption) (*Message, error)
 
 	// Request publishes a message synchronously and waits for a response that
 	// is decoded into the Protobuf message supplied. The wrapped message is
-	// returned or an error. The error can inspected using status.FromError.
-	Request(sub string, req proto.Message, rep proto.Message, opts ...RequestOption) (*Message, error)
+	// returned or an error. Errors of the handler and of the connection, such
+	// as timeouts, are status errors and can be inspected using
+	// status.FromError. The deadline and trace context of the context are sent with the
+	// request so the handler can honor and continue them.
+	Request(ctx context.Context, sub string, req proto.Message, rep proto.Message, opts ...RequestOption) (*Message, error)
+
+	// RequestAll publishes a message once and collects the replies of every
+	// subscriber, rather than only the first, until the count or quorum set
+	// using RequestCount or RequestQuorum is reached, or the request times
+	// out. Each reply is returned with its message and status.
+	RequestAll(ctx context.Context, sub string, req proto.Message, rep proto.Message, opts ...RequestOption) ([]*Reply, error)
+
+	// RequestAsync sends a request and returns a future of the reply
+	// without waiting for it. Replies are received on an inbox shared by
+	// all asynchronous requests of the transport rather than one per
+	// request, so many requests can be in flight cheaply.
+	RequestAsync(ctx context.Context, sub string, req proto.Message, rep proto.Message, opts ...RequestOption) Future
+
+	// Stream opens a stream by sending the request, which may be nil, and
+	// returns the client side of the stream. The handler of the request
+	// obtains the server side using ServerStreamFromContext. The request
+	// timeout only applies if set explicitly, the stream is otherwise bound
+	// by the context.
+	Stream(ctx context.Context, sub string, req proto.Message, opts ...RequestOption) (ClientStream, error)
 
 	// Subscribe creates a subscription to a subject.
-	Subscribe(sub string, hdl Handler, opts ...SubscribeOption) (*nats.Subscription, error)
+	Subscribe(sub string, hdl Handler, opts ...SubscribeOption) (Subscription, error)
 
-	// Conn returns the underlying NATS connection.
+	// Conn returns the underlying NATS connection. It is nil for transports
+	// using a MemoryBroker.
 	Conn() *nats.Conn
 
 	// Close closes the transport connection and unsubscribes all subscribers.
@@ -107,34 +362,78 @@ type Transport interface {
 
 // Connect is a convenience function establishing a connection with
 // NATS and returning a transport.
-func Connect(opts *nats.Options) (Transport, error) {
+func Connect(opts *nats.Options, topts ...Option) (Transport, error) {
 	conn, err := opts.Connect()
 	if err != nil {
 		return nil, err
 	}
 
-	logger, _ := zap.NewProduction()
-	return &transport{
-		logger: logger,
-		conn:   conn,
-	}, nil
+	return New(conn, topts...), nil
 }
 
 // New returns a transport using an existing NATS connection.
-func New(conn *nats.Conn) Transport {
+func New(conn *nats.Conn, opts ...Option) Transport {
+	return open(conn, natsBroker{conn}, opts)
+}
+
+// open returns a transport sending messages through the broker.
+func open(nc *nats.Conn, conn broker, opts []Option) *transport {
+	tOpts := &Options{
+		Codec:                ProtoCodec,
+		CompressionThreshold: DefaultCompressionThreshold,
+		MaxMessageSize:       DefaultMaxMessageSize,
+		ChunkTimeout:         DefaultChunkTimeout,
+		Propagator:           propagation.TraceContext{},
+		Metrics:              NopMetrics,
+		StreamWindow:         DefaultStreamWindow,
+		StreamHeartbeat:      DefaultStreamHeartbeat,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(tOpts)
+	}
+
 	logger, _ := zap.NewProduction()
+	ctx, cancel := context.WithCancel(context.Background())
 
-	return &transport{
+	c := &transport{
 		logger: logger,
+		nc:     nc,
 		conn:   conn,
+		opts:   tOpts,
+		ctx:    ctx,
+		cancel: cancel,
 	}
+
+	if tOpts.CircuitBreaker != nil {
+		c.breakers = newBreakers(tOpts.CircuitBreaker, func() *zap.Logger {
+			return c.logger
+		})
+	}
+
+	return c
 }
 
 type transport struct {
 	logger *zap.Logger
-	conn   *nats.Conn
-	subs   []*nats.Subscription
+	nc     *nats.Conn
+	conn   broker
+	opts   *Options
+	subs   []*subscription
 	mux    sync.Mutex
+
+	// breakers are the circuits of the circuit breaker, if enabled.
+	breakers *breakers
+
+	// replyMux routes the replies of asynchronous requests, once one is
+	// made.
+	replyMux *replyMux
+
+	// ctx is the default parent of handler contexts and is cancelled
+	// when the transport is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (c *transport) SetLogger(l *zap.Logger) {
@@ -142,25 +441,31 @@ func (c *transport) SetLogger(l *zap.Logger) {
 }
 
 func (c *transport) Conn() *nats.Conn {
-	return c.conn
+	return c.nc
 }
 
 func (c *transport) Close() {
-	for _, sub := range c.subs {
+	c.mux.Lock()
+	subs := c.subs
+	c.subs = nil
+	c.mux.Unlock()
+
+	for _, sub := range subs {
 		sub.Unsubscribe()
 	}
+	c.cancel()
 	c.conn.Close()
 }
 
-// TODO: define as part of an Encoder interface.
-func (c *transport) wrap(payload proto.Message) (*Message, error) {
+// wrap encodes the payload using the codec and wraps it in a new message.
+func (c *transport) wrap(payload proto.Message, codec Codec) (*Message, error) {
 	var (
 		pb  []byte
 		err error
 	)
 
 	if payload != nil {
-		pb, err = proto.Marshal(payload)
+		pb, err = codec.Marshal(payload)
 		if err != nil {
 			return nil, err
 		}
@@ -170,15 +475,17 @@ func (c *transport) wrap(payload proto.Message) (*Message, error) {
 	ts := time.Now().UnixNano()
 
 	msg := Message{
-		Id:        id,
-		Timestamp: uint64(ts),
-		Payload:   pb,
+		Id:          id,
+		Timestamp:   uint64(ts),
+		Payload:     pb,
+		ContentType: codec.ContentType(),
 	}
 
 	return &msg, nil
 }
 
-// TODO: define as part of a Decoder interface.
+// unwrap decodes the message envelope. The envelope itself is always
+// encoded using protobuf, the payload is decoded lazily by Message.Decode.
 func (c *transport) unwrap(nmsg *nats.Msg) (*Message, error) {
 	var msg Message
 
@@ -193,38 +500,71 @@ func (c *transport) unwrap(nmsg *nats.Msg) (*Message, error) {
 	return &msg, nil
 }
 
-func (c *transport) Publish(sub string, msg proto.Message, opts ...PublishOption) (*Message, error) {
-	pubOpts := &PublishOptions{}
+func (c *transport) Publish(ctx context.Context, sub string, msg proto.Message, opts ...PublishOption) (*Message, error) {
+	pubOpts := &PublishOptions{
+		Codec:      c.opts.Codec,
+		Compressor: c.opts.Compressor,
+	}
 
 	// Apply options.
 	for _, opt := range opts {
 		opt(pubOpts)
 	}
 
-	// TODO: use encoder
-	m, err := c.wrap(msg)
+	m, err := c.wrap(msg, pubOpts.Codec)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := compress(m, pubOpts.Compressor, c.opts.CompressionThreshold); err != nil {
+		return nil, err
+	}
+
 	m.Subject = sub
 	m.Cause = pubOpts.Cause
+	m.Metadata = pubOpts.Metadata
+
+	ctx, span := c.startClientSpan(ctx, m, trace.SpanKindProducer)
+
+	start := time.Now()
+	c.opts.Metrics.ClientStarted(KindPublish, sub, len(m.Payload))
+
+	invoke := chainInvoker(
+		joinClientInterceptors(c.opts.ClientInterceptors, pubOpts.Interceptors),
+		c.publish,
+	)
+
+	_, err = invoke(ctx, m)
+	err = statusError(err)
+	endSpan(span, err)
+
+	c.opts.Metrics.ClientHandled(KindPublish, sub, errorStatus(err).Code(), time.Since(start), 0)
 
-	mb, err := proto.Marshal(m)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := c.conn.Publish(sub, mb); err != nil {
+	return m, nil
+}
+
+// publish is the invoker sending a publication. Chunks of large messages
+// are served until the chunk timeout since there may be any number of
+// subscribers fetching them.
+func (c *transport) publish(ctx context.Context, m *Message) (*Message, error) {
+	mb, err := c.encode(c.ctx, m)
+	if err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	// The reply subject is only set on messages opening a stream.
+	return nil, statusError(c.conn.PublishRequest(m.Subject, m.Reply, mb))
 }
 
-func (c *transport) Request(sub string, req proto.Message, rep proto.Message, opts ...RequestOption) (*Message, error) {
+func (c *transport) Request(ctx context.Context, sub string, req proto.Message, rep proto.Message, opts ...RequestOption) (*Message, error) {
 	reqOpts := &RequestOptions{
-		Timeout: DefaultRequestTimeout,
+		Timeout:    DefaultRequestTimeout,
+		Codec:      c.opts.Codec,
+		Compressor: c.opts.Compressor,
 	}
 
 	// Apply options.
@@ -232,82 +572,192 @@ func (c *transport) Request(sub string, req proto.Message, rep proto.Message, op
 		opt(reqOpts)
 	}
 
-	m, err := c.wrap(req)
+	// The timeout applies in addition to any deadline already set on the
+	// context, so the earlier of the two wins.
+	if reqOpts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, reqOpts.Timeout)
+		defer cancel()
+	}
+
+	m, err := c.wrap(req, reqOpts.Codec)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := compress(m, reqOpts.Compressor, c.opts.CompressionThreshold); err != nil {
+		return nil, err
+	}
+
 	m.Subject = sub
 	m.Cause = reqOpts.Cause
+	m.Metadata = reqOpts.Metadata
+
+	retry := reqOpts.Retry
+	if retry == nil {
+		retry = c.opts.RetryPolicies[sub]
+	}
+
+	ctx, span := c.startClientSpan(ctx, m, trace.SpanKindClient)
+
+	start := time.Now()
+	c.opts.Metrics.ClientStarted(KindRequest, sub, len(m.Payload))
+
+	invoke := chainInvoker(
+		joinClientInterceptors(c.opts.ClientInterceptors, reqOpts.Interceptors),
+		c.request,
+	)
+
+	if reqOpts.HedgeDelay > 0 {
+		invoke = c.hedged(reqOpts.HedgeDelay, invoke)
+	}
+
+	m, err = c.invokeCircuit(ctx, retry, m, invoke)
+	err = statusError(err)
+	endSpan(span, err)
+
+	var size int
+	if m != nil {
+		size = len(m.Payload)
+	}
+
+	c.opts.Metrics.ClientHandled(KindRequest, sub, errorStatus(err).Code(), time.Since(start), size)
 
-	mb, err := proto.Marshal(m)
 	if err != nil {
 		return nil, err
 	}
 
-	// Send request.
-	nm, err := c.conn.Request(sub, mb, reqOpts.Timeout)
+	if rep != nil {
+		if err := m.Decode(rep); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// request is the invoker sending a request and waiting for the reply.
+func (c *transport) request(ctx context.Context, m *Message) (*Message, error) {
+	mb, err := c.encode(ctx, m)
 	if err != nil {
 		return nil, err
 	}
 
-	m, err = c.unwrap(nm)
+	// Send request. Failures of the connection are returned as status
+	// errors like those of the handler.
+	nm, err := c.conn.RequestWithContext(ctx, m.Subject, mb)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	rm, err := c.unwrap(nm)
 	if err != nil {
 		return nil, err
 	}
 
+	if rm.Chunks > 0 {
+		rm, err = c.reassemble(ctx, rm)
+		if err != nil {
+			return nil, statusError(err)
+		}
+	}
+
+	return rm, replyError(rm)
+}
+
+// replyError returns the error of the handler replying with the message,
+// if any.
+func replyError(rm *Message) error {
 	// If older transport code is being used with the new message format
 	// status could be nil.
-	if m.Status != nil {
-		sts := status.FromProto(m.Status)
+	if rm.Status != nil {
+		sts := status.FromProto(rm.Status)
 
 		// Error will be nil if code is OK.
-		if err := sts.Err(); err != nil {
-			return nil, err
-		}
+		return sts.Err()
+	}
 
-		// Deprecated.
-		// Error occurred in the handler.
-	} else if m.Error != "" {
-		return nil, errors.New(m.Error)
+	// Deprecated.
+	// Error occurred in the handler.
+	if rm.Error != "" {
+		return errors.New(rm.Error)
 	}
 
-	if rep != nil {
-		if err := proto.Unmarshal(m.Payload, rep); err != nil {
-			return nil, err
-		}
+	return nil
+}
+
+// replyCodec returns the codec for replying to the message. The codec of the
+// request is used if it is registered, otherwise the transport default.
+func (c *transport) replyCodec(msg *Message) Codec {
+	if codec, err := codecFor(msg.ContentType); err == nil {
+		return codec
 	}
 
-	return m, nil
+	return c.opts.Codec
 }
 
-// errorStatus takes an error and returns the internal status or wraps it.
-func errorStatus(err error) *status.Status {
-	if err == nil {
-		return status.New(codes.OK, "")
+// replyCompressor returns the compressor for replying to the message. The
+// subscription compressor takes precedence, then the compressor of the
+// request if it is registered, then the transport default.
+func (c *transport) replyCompressor(subOpts *SubscribeOptions, msg *Message) Compressor {
+	if subOpts.Compressor != nil {
+		return subOpts.Compressor
 	}
 
-	// Check if this is a valid status, otherwise convert to one.
-	sts, ok := status.FromError(err)
-	if !ok {
-		sts = status.New(codes.Unknown, err.Error())
+	if comp, err := compressorFor(msg.ContentEncoding); err == nil && comp != nil {
+		return comp
 	}
 
-	return sts
+	return c.opts.Compressor
+}
+
+// serverKind returns the kind of call the message was sent by.
+func serverKind(msg *Message) string {
+	switch {
+	case msg.Reply == "":
+		return KindPublish
+	case msg.Frame == Frame_OPEN:
+		return KindStream
+	}
+
+	return KindRequest
+}
+
+// messageContext returns a context derived from parent that carries the
+// deadline of the message, if one was set.
+func messageContext(parent context.Context, msg *Message) (context.Context, context.CancelFunc) {
+	if msg.Deadline == 0 {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithDeadline(parent, time.Unix(0, int64(msg.Deadline)))
 }
 
 // Subscribe creates a subscription to a subject.
-func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption) (*nats.Subscription, error) {
-	subOpts := &SubscribeOptions{}
+func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption) (Subscription, error) {
+	subOpts := &SubscribeOptions{
+		Context:      c.ctx,
+		GracePeriod:  DefaultGracePeriod,
+		PendingLimit: DefaultPendingLimit,
+	}
 
 	// Apply options.
 	for _, opt := range opts {
 		opt(subOpts)
 	}
 
+	s := newSubscription(subOpts.Context, subOpts.GracePeriod)
+	lim := newLimiter(subOpts)
+
+	// Wrap the handler with the transport and subscription interceptors.
+	hdlr = chainHandler(
+		joinServerInterceptors(c.opts.ServerInterceptors, subOpts.Interceptors),
+		hdlr,
+	)
+
 	// Replies to the recipient with an error if applicable.
 	replyWithError := func(logger *zap.Logger, msg *Message, sts *status.Status) {
-		rmsg, err := c.wrap(nil)
+		rmsg, err := c.wrap(nil, c.opts.Codec)
 		// If this fails, this is a bug.
 		if err != nil {
 			logger.Error("failed to create transport message",
@@ -319,6 +769,11 @@ func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption)
 		rmsg.Cause = msg.Id
 		rmsg.Subject = msg.Reply
 		rmsg.Status = sts.Proto()
+
+		// The error ends the stream opened by the message.
+		if msg.Frame == Frame_OPEN {
+			rmsg.Frame = Frame_END
+		}
 		// Backwards compatibility for older transports consuming new messages.
 		rmsg.Error = sts.Err().Error()
 
@@ -338,34 +793,13 @@ func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption)
 		}
 	}
 
-	// NATS message handler. At this point the message has been sent over
-	// the wire and received, so any errors should be wrapped using an appropriate
-	// status code.
-	natsHandler := func(nmsg *nats.Msg) {
-		// Copy logger for this request.
-		logger := c.logger.With(
-			zap.String("msg.subject", nmsg.Subject),
-			zap.String("msg.reply", nmsg.Reply),
-		)
-
-		// Failed to decode message.
-		msg, err := c.unwrap(nmsg)
-
-		// Failed unwrap which means the message is likely in the wrong format.
-		// A reply is ignored if this occurs since if the sent message was invalid
-		// it is unlikely the requester will be able to parse the message in the
-		// same format. Instead we log this case.
-		// TODO: reply with error.
-		if err != nil {
-			logger.Error("failed to decode nats message")
-			return
-		}
-
-		// Add more context now that wrapped message has been decoded.
-		logger = c.logger.With(
-			zap.String("trace.id", msg.Id),
-			zap.String("msg.id", msg.Id),
-			zap.String("msg.cause", msg.Cause),
+	// Handles a decoded message, in the goroutine of the stream it opens if
+	// it opens one.
+	handleMessage := func(logger *zap.Logger, msg *Message) {
+		// Set once started, so they are ended if the handler panics.
+		var (
+			span   trace.Span
+			stream *stream
 		)
 
 		// In case the handler panics, catch and log.
@@ -375,6 +809,24 @@ func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption)
 				// recovered value is.
 				err := fmt.Errorf("%s", rec)
 
+				if span != nil {
+					endSpan(span, err)
+				}
+
+				// The peer is waiting for the stream to end.
+				if stream != nil {
+					logger.Error("stream handler panic",
+						zap.Error(err),
+					)
+
+					if err := stream.end(status.Error(codes.Internal, err.Error())); err != nil {
+						logger.Error("failed to end stream",
+							zap.Error(err),
+						)
+					}
+					return
+				}
+
 				if msg.Reply == "" {
 					logger.Error("subscription handler panic",
 						zap.Error(err),
@@ -387,8 +839,130 @@ func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption)
 			}
 		}()
 
+		ctx, cancel := messageContext(s.ctx, msg)
+		defer cancel()
+
+		// The requester has already given up on a reply or the subscriber is
+		// shutting down, so there is no point in doing the work.
+		if ctx.Err() != nil {
+			logger.Debug("skipping message",
+				zap.Error(ctx.Err()),
+			)
+			return
+		}
+
+		ctx, span = c.startServerSpan(ctx, msg)
+
+		// Log the id of the distributed trace in place of the message id.
+		if sc := span.SpanContext(); sc.IsValid() {
+			logger = c.logger.With(
+				zap.String("trace.id", sc.TraceID().String()),
+				zap.String("msg.id", msg.Id),
+				zap.String("msg.cause", msg.Cause),
+			)
+		}
+
+		// The size of a message sent in chunks is known from its header.
+		recvSize := len(msg.Payload)
+		if msg.Chunks > 0 {
+			recvSize = int(msg.Size)
+		}
+
+		start := time.Now()
+		c.opts.Metrics.ServerStarted(msg.Subject, msg.Queue, recvSize)
+
+		// Recorded once the reply is sent. The code is unknown if the
+		// handler panics.
+		var (
+			code = codes.Unknown
+			size int
+		)
+
+		defer func() {
+			c.opts.Metrics.ServerHandled(serverKind(msg), msg.Subject, msg.Queue, code, time.Since(start), size)
+		}()
+
+		// Reject the message before it is handled if a limit is reached.
+		// Messages sent in chunks are rejected before the chunks are
+		// fetched. The latency of admitted messages is recorded once
+		// handled, except for streams.
+		var latency time.Duration
+
+		if lim != nil {
+			if sts := lim.admit(msg); sts != nil {
+				endSpan(span, sts.Err())
+				code = sts.Code()
+
+				logger.Debug("rejecting message",
+					zap.Error(sts.Err()),
+				)
+
+				if msg.Reply != "" {
+					replyWithError(logger, msg, sts)
+				}
+				return
+			}
+
+			defer func() {
+				lim.done(latency)
+			}()
+		}
+
+		// Fetch the full message if it was sent in chunks.
+		if msg.Chunks > 0 {
+			full, err := c.reassemble(ctx, msg)
+			if err != nil {
+				endSpan(span, err)
+				code = errorStatus(err).Code()
+
+				if msg.Reply == "" {
+					logger.Error("failed to reassemble chunked message",
+						zap.Error(err),
+					)
+					return
+				}
+
+				replyWithError(logger, msg, errorStatus(err))
+				return
+			}
+
+			msg = full
+		}
+
+		ctx = NewContext(ctx, msg)
+
+		// The handler of a message opening a stream sends replies using the
+		// stream from the context. The stream ends when it returns.
+		if msg.Frame == Frame_OPEN && msg.Reply != "" {
+			var err error
+
+			stream, err = c.newServerStream(ctx, subOpts, msg)
+			if err != nil {
+				endSpan(span, err)
+				code = errorStatus(err).Code()
+				replyWithError(logger, msg, errorStatus(err))
+				return
+			}
+
+			_, err = hdlr(stream.ctx, msg)
+			endSpan(span, err)
+
+			code = errorStatus(err).Code()
+
+			if err := stream.end(err); err != nil {
+				logger.Error("failed to end stream",
+					zap.Error(err),
+				)
+			}
+			return
+		}
+
 		// Pass message to handler.
-		resp, err := hdlr(msg)
+		resp, err := hdlr(ctx, msg)
+		latency = time.Since(start)
+		endSpan(span, err)
+
+		code = errorStatus(err).Code()
 
 		// Log error only if no reply.
 		if msg.Reply == "" {
@@ -412,27 +986,46 @@ func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption)
 		// This will only fail if the response itself cannot be marshaled which
 		// means the handler is likely at fault. The error should be logged
 		// and a reply with a faulty handler can be returned.
-		rmsg, err := c.wrap(resp)
+		rmsg, err := c.wrap(resp, c.replyCodec(msg))
 		if err != nil {
 			logger.Error("failed to marshal response message",
 				zap.Error(err),
 			)
 
 			sts := errorStatus(err)
+			code = sts.Code()
 			replyWithError(logger, msg, sts)
 			return
 		}
 
+		if err := compress(rmsg, c.replyCompressor(subOpts, msg), c.opts.CompressionThreshold); err != nil {
+			logger.Error("failed to compress response message",
+				zap.Error(err),
+			)
+
+			sts := errorStatus(err)
+			code = sts.Code()
+			replyWithError(logger, msg, sts)
+			return
+		}
+
+		size = len(rmsg.Payload)
+
 		rmsg.Cause = msg.Id
 		rmsg.Subject = msg.Reply
 		rmsg.Status = status.New(codes.OK, "").Proto()
 
-		// If this fails, this is a bug.
-		mb, err := proto.Marshal(rmsg)
+		// This fails if the reply is a bug or too large to be sent, in the
+		// latter case the requester is told so.
+		mb, err := c.encode(c.ctx, rmsg)
 		if err != nil {
 			logger.Error("failed to marshal transport message",
 				zap.Error(err),
 			)
+
+			if _, ok := status.FromError(err); ok {
+				replyWithError(logger, msg, errorStatus(err))
+			}
 			return
 		}
 
@@ -444,22 +1037,123 @@ func (c *transport) Subscribe(sub string, hdlr Handler, opts ...SubscribeOption)
 		}
 	}
 
-	// Queue-based subscriber.
-	if subOpts.Queue != "" {
-		s, err := c.conn.QueueSubscribe(sub, subOpts.Queue, natsHandler)
+	// NATS message handler. At this point the message has been sent over
+	// the wire and received, so any errors should be wrapped using an appropriate
+	// status code.
+	natsHandler := func(nmsg *nats.Msg) {
+		// Copy logger for this request.
+		logger := c.logger.With(
+			zap.String("msg.subject", nmsg.Subject),
+			zap.String("msg.reply", nmsg.Reply),
+		)
+
+		// Failed to decode message.
+		msg, err := c.unwrap(nmsg)
+
+		// Failed unwrap which means the message is likely in the wrong format.
+		// A reply is ignored if this occurs since if the sent message was invalid
+		// it is unlikely the requester will be able to parse the message in the
+		// same format. Instead we log this case.
+		// TODO: reply with error.
 		if err != nil {
-			return nil, err
+			logger.Error("failed to decode nats message")
+			return
 		}
 
-		c.mux.Lock()
-		c.subs = append(c.subs, s)
-		c.mux.Unlock()
-		return s, nil
+		// Add more context now that wrapped message has been decoded.
+		logger = c.logger.With(
+			zap.String("trace.id", msg.Id),
+			zap.String("msg.id", msg.Id),
+			zap.String("msg.cause", msg.Cause),
+		)
+
+		// Streams may be long-lived, so each is handled in its own goroutine
+		// rather than holding up the subscription or its workers. It is
+		// tracked so Drain waits for it.
+		if msg.Frame == Frame_OPEN && msg.Reply != "" {
+			if !s.begin() {
+				replyWithError(logger, msg, status.New(codes.Unavailable, "subscription is draining"))
+				return
+			}
+
+			go func() {
+				defer s.end()
+				handleMessage(logger, msg)
+			}()
+			return
+		}
+
+		handleMessage(logger, msg)
+	}
+
+	// Replies to a message that is not handled.
+	reject := func(nmsg *nats.Msg, sts *status.Status) {
+		if nmsg.Reply == "" {
+			return
+		}
+
+		msg, err := c.unwrap(nmsg)
+		if err != nil {
+			return
+		}
+
+		replyWithError(c.logger.With(zap.String("msg.id", msg.Id)), msg, sts)
+	}
+
+	// Messages are handled by the NATS callback, one at a time, or queued
+	// for a pool of workers.
+	cb := func(nmsg *nats.Msg) {
+		// Messages still delivered while draining are turned away so the
+		// requester can try another subscriber.
+		if !s.begin() {
+			reject(nmsg, status.New(codes.Unavailable, "subscription is draining"))
+			return
+		}
+		defer s.end()
+
+		natsHandler(nmsg)
 	}
 
-	// Standalone subscriber.
-	s, err := c.conn.Subscribe(sub, natsHandler)
+	if subOpts.Concurrency > 1 {
+		pending := func(n int) {
+			c.opts.Metrics.ServerPending(sub, subOpts.Queue, n)
+		}
+
+		s.startWorkers(subOpts.Concurrency, subOpts.PendingLimit, natsHandler, pending)
+
+		cb = func(nmsg *nats.Msg) {
+			if !s.begin() {
+				reject(nmsg, status.New(codes.Unavailable, "subscription is draining"))
+				return
+			}
+
+			// The workers cannot keep up, so the requester is told rather
+			// than left to time out.
+			if !s.enqueue(nmsg) {
+				s.end()
+
+				c.logger.Warn("slow consumer, dropping message",
+					zap.String("msg.subject", nmsg.Subject),
+					zap.Int("pending.limit", subOpts.PendingLimit),
+				)
+
+				c.opts.Metrics.ServerDropped(sub, subOpts.Queue)
+				reject(nmsg, status.New(codes.ResourceExhausted, "slow consumer: pending limit reached"))
+			}
+		}
+	}
+
+	var err error
+
+	// Queue-based subscriber or standalone subscriber.
+	if subOpts.Queue != "" {
+		s.sub, err = c.conn.QueueSubscribe(sub, subOpts.Queue, cb)
+	} else {
+		s.sub, err = c.conn.Subscribe(sub, cb)
+	}
 	if err != nil {
+		s.stop()
+		s.cancel()
 		return nil, err
 	}
 
@@ -29,6 +29,48 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+// Frame is the role of a message in a call.
+type Frame int32
+
+const (
+	// UNARY is a publication, request or reply outside of a stream.
+	Frame_UNARY Frame = 0
+	// OPEN is the request opening a stream. Frames are sent to the reply
+	// subject of the peer, which is that of the last frame received from it.
+	Frame_OPEN Frame = 1
+	// DATA is a message of a stream.
+	Frame_DATA Frame = 2
+	// END ends the sending side of a stream. Sent by the server it ends the
+	// stream and the status is the result of the stream.
+	Frame_END Frame = 3
+	// CREDIT grants the peer credit to send more DATA frames.
+	Frame_CREDIT Frame = 4
+	// CANCEL cancels the stream.
+	Frame_CANCEL Frame = 5
+)
+
+var Frame_name = map[int32]string{
+	0: "UNARY",
+	1: "OPEN",
+	2: "DATA",
+	3: "END",
+	4: "CREDIT",
+	5: "CANCEL",
+}
+var Frame_value = map[string]int32{
+	"UNARY":  0,
+	"OPEN":   1,
+	"DATA":   2,
+	"END":    3,
+	"CREDIT": 4,
+	"CANCEL": 5,
+}
+
+func (x Frame) String() string {
+	return proto.EnumName(Frame_name, int32(x))
+}
+func (Frame) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
 // Message is the envelope/wrapper for all messages.
 type Message struct {
 	// ID is a globally unique message.
@@ -36,7 +78,7 @@ type Message struct {
 	// Timestamp is the timestamp in nanoseconds with the message was published.
 	Timestamp uint64 `protobuf:"varint,2,opt,name=timestamp" json:"timestamp,omitempty"`
 	// Payload is the actual payload being published that will be consumed.
-	// This is expected to use protobuf encoding.
+	// It is encoded using the codec identified by the content type.
 	Payload []byte `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
 	// Deprecated. Use status instead.
 	Error string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
@@ -50,6 +92,39 @@ type Message struct {
 	Reply string `protobuf:"bytes,8,opt,name=reply" json:"reply,omitempty"`
 	// Error that occurs in during transport or in the application.
 	Status *google_rpc.Status `protobuf:"bytes,9,opt,name=status" json:"status,omitempty"`
+	// Deadline is the timestamp in nanoseconds by which the publisher expects
+	// a reply. Zero means no deadline was set.
+	Deadline uint64 `protobuf:"varint,10,opt,name=deadline" json:"deadline,omitempty"`
+	// Metadata holds arbitrary key/value pairs sent along with the payload,
+	// such as auth tokens, tenant ids or feature flags.
+	Metadata map[string]string `protobuf:"bytes,11,rep,name=metadata" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// ContentType identifies the codec used to encode the payload. An empty
+	// value means protobuf.
+	ContentType string `protobuf:"bytes,12,opt,name=content_type,json=contentType" json:"content_type,omitempty"`
+	// ContentEncoding identifies the compression applied to the encoded
+	// payload. An empty value means the payload is not compressed.
+	ContentEncoding string `protobuf:"bytes,13,opt,name=content_encoding,json=contentEncoding" json:"content_encoding,omitempty"`
+	// Chunks is the number of chunks the full message was split into because
+	// it exceeded the max payload of the connection. If set, this message is
+	// only a header and the full message is fetched from the chunk subject.
+	Chunks uint32 `protobuf:"varint,14,opt,name=chunks" json:"chunks,omitempty"`
+	// ChunkSubject is the subject serving the chunks of the full message.
+	ChunkSubject string `protobuf:"bytes,15,opt,name=chunk_subject,json=chunkSubject" json:"chunk_subject,omitempty"`
+	// Size is the size in bytes of the full message when chunked.
+	Size uint64 `protobuf:"varint,16,opt,name=size" json:"size,omitempty"`
+	// TraceContext carries the W3C trace context of the span the message
+	// was sent in, such as the traceparent and tracestate headers.
+	TraceContext map[string]string `protobuf:"bytes,17,rep,name=trace_context,json=traceContext" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Frame is the role of the message in a stream.
+	Frame Frame `protobuf:"varint,18,opt,name=frame,enum=transport.Frame" json:"frame,omitempty"`
+	// Sequence is the position of a DATA frame in a stream starting at one.
+	Sequence uint64 `protobuf:"varint,19,opt,name=sequence" json:"sequence,omitempty"`
+	// Credit is the number of DATA frames the peer may send in addition to
+	// those it was granted before. It is set on OPEN and CREDIT frames.
+	Credit uint32 `protobuf:"varint,20,opt,name=credit" json:"credit,omitempty"`
+	// Hedge is set on a copy of a request sent because the original was not
+	// replied to in time.
+	Hedge bool `protobuf:"varint,21,opt,name=hedge" json:"hedge,omitempty"`
 }
 
 func (m *Message) Reset()                    { *m = Message{} }
@@ -120,26 +195,131 @@ func (m *Message) GetStatus() *google_rpc.Status {
 	return nil
 }
 
+func (m *Message) GetDeadline() uint64 {
+	if m != nil {
+		return m.Deadline
+	}
+	return 0
+}
+
+func (m *Message) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *Message) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *Message) GetContentEncoding() string {
+	if m != nil {
+		return m.ContentEncoding
+	}
+	return ""
+}
+
+func (m *Message) GetChunks() uint32 {
+	if m != nil {
+		return m.Chunks
+	}
+	return 0
+}
+
+func (m *Message) GetChunkSubject() string {
+	if m != nil {
+		return m.ChunkSubject
+	}
+	return ""
+}
+
+func (m *Message) GetSize() uint64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *Message) GetTraceContext() map[string]string {
+	if m != nil {
+		return m.TraceContext
+	}
+	return nil
+}
+
+func (m *Message) GetFrame() Frame {
+	if m != nil {
+		return m.Frame
+	}
+	return Frame_UNARY
+}
+
+func (m *Message) GetSequence() uint64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *Message) GetCredit() uint32 {
+	if m != nil {
+		return m.Credit
+	}
+	return 0
+}
+
+func (m *Message) GetHedge() bool {
+	if m != nil {
+		return m.Hedge
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*Message)(nil), "transport.Message")
+	proto.RegisterEnum("transport.Frame", Frame_name, Frame_value)
 }
 
 func init() { proto.RegisterFile("transport.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 220 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x44, 0x8f, 0xc1, 0x4a, 0xc4, 0x30,
-	0x10, 0x86, 0x49, 0xdd, 0x6d, 0x6d, 0x14, 0x85, 0x20, 0x38, 0x88, 0x87, 0xe2, 0xa9, 0x78, 0xe8,
-	0x82, 0x3e, 0x87, 0x97, 0xfa, 0x04, 0xd9, 0x74, 0x28, 0x95, 0xae, 0x89, 0x33, 0x93, 0xc3, 0x3e,
-	0xb5, 0xaf, 0x20, 0x49, 0xac, 0x1e, 0xbf, 0x6f, 0x3e, 0x06, 0x7e, 0x7d, 0x2b, 0x64, 0x3f, 0x39,
-	0x78, 0x92, 0x21, 0x90, 0x17, 0x6f, 0xda, 0x3f, 0xf1, 0x70, 0x3f, 0x7b, 0x3f, 0xaf, 0x78, 0xa0,
-	0xe0, 0x0e, 0x2c, 0x56, 0x22, 0x97, 0xe6, 0xe9, 0x5b, 0xe9, 0xe6, 0x0d, 0x99, 0xed, 0x8c, 0xe6,
-	0x46, 0x57, 0xcb, 0x04, 0xaa, 0x53, 0x7d, 0x3b, 0x56, 0xcb, 0x64, 0x1e, 0x75, 0x2b, 0xcb, 0x09,
-	0x59, 0xec, 0x29, 0x40, 0xd5, 0xa9, 0x7e, 0x37, 0xfe, 0x0b, 0x03, 0xba, 0x09, 0xf6, 0xbc, 0x7a,
-	0x3b, 0xc1, 0x45, 0xa7, 0xfa, 0xeb, 0x71, 0x43, 0x73, 0xa7, 0xf7, 0x48, 0xe4, 0x09, 0x76, 0xf9,
-	0x55, 0x81, 0x64, 0x9d, 0x8d, 0x8c, 0xb0, 0x2f, 0x36, 0x43, 0xfa, 0xc2, 0xf1, 0xf8, 0x81, 0x4e,
-	0xa0, 0xce, 0x7e, 0xc3, 0xd4, 0x7f, 0x45, 0x8c, 0x08, 0x4d, 0xe9, 0x33, 0x24, 0x4b, 0x18, 0xd6,
-	0x33, 0x5c, 0x16, 0x9b, 0xc1, 0x3c, 0xeb, 0xba, 0xac, 0x82, 0xb6, 0x53, 0xfd, 0xd5, 0x8b, 0x19,
-	0xca, 0xde, 0x81, 0x82, 0x1b, 0xde, 0xf3, 0x65, 0xfc, 0x2d, 0x8e, 0x75, 0x1e, 0xfe, 0xfa, 0x13,
-	0x00, 0x00, 0xff, 0xff, 0xdd, 0x4d, 0x61, 0xd5, 0x2f, 0x01, 0x00, 0x00,
+	// 539 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x93, 0x5f, 0x8f, 0xd2, 0x40,
+	0x14, 0xc5, 0x2d, 0xd0, 0x42, 0x2f, 0xb0, 0x74, 0xc7, 0x55, 0x27, 0xc4, 0x87, 0xfa, 0x27, 0xa6,
+	0xee, 0x43, 0x37, 0xc1, 0x17, 0xa3, 0x26, 0x86, 0x40, 0x35, 0x9b, 0xb8, 0x68, 0xba, 0xf8, 0xe0,
+	0x13, 0x99, 0x6d, 0xaf, 0x6c, 0x5d, 0x68, 0xbb, 0xd3, 0xa9, 0xb1, 0x7e, 0x1d, 0xbf, 0xa8, 0xe9,
+	0x9d, 0xc2, 0xae, 0xd1, 0x17, 0xdf, 0xee, 0xf9, 0xcd, 0x99, 0x0b, 0xe7, 0x30, 0xc0, 0x48, 0x49,
+	0x91, 0x16, 0x79, 0x26, 0x95, 0x9f, 0xcb, 0x4c, 0x65, 0xcc, 0xde, 0x83, 0xf1, 0x83, 0x75, 0x96,
+	0xad, 0x37, 0x78, 0x22, 0xf3, 0xe8, 0xa4, 0x50, 0x42, 0x95, 0x85, 0xf6, 0x3c, 0xfe, 0x65, 0x41,
+	0xf7, 0x0c, 0x8b, 0x42, 0xac, 0x91, 0x1d, 0x40, 0x2b, 0x89, 0xb9, 0xe1, 0x1a, 0x9e, 0x1d, 0xb6,
+	0x92, 0x98, 0x3d, 0x04, 0x5b, 0x25, 0x5b, 0x2c, 0x94, 0xd8, 0xe6, 0xbc, 0xe5, 0x1a, 0x5e, 0x27,
+	0xbc, 0x01, 0x8c, 0x43, 0x37, 0x17, 0xd5, 0x26, 0x13, 0x31, 0x6f, 0xbb, 0x86, 0x37, 0x08, 0x77,
+	0x92, 0x1d, 0x81, 0x89, 0x52, 0x66, 0x92, 0x77, 0x68, 0x95, 0x16, 0x35, 0x8d, 0x44, 0x59, 0x20,
+	0x37, 0x35, 0x25, 0x51, 0x6f, 0x29, 0xca, 0x8b, 0x6f, 0x18, 0x29, 0x6e, 0x11, 0xdf, 0xc9, 0xda,
+	0x7f, 0x5d, 0x62, 0x89, 0xbc, 0xab, 0xfd, 0x24, 0x6a, 0x2a, 0x31, 0xdf, 0x54, 0xbc, 0xa7, 0x29,
+	0x09, 0x76, 0x0c, 0x96, 0x4e, 0xc5, 0x6d, 0xd7, 0xf0, 0xfa, 0x13, 0xe6, 0xeb, 0xbc, 0xbe, 0xcc,
+	0x23, 0xff, 0x9c, 0x4e, 0xc2, 0xc6, 0xc1, 0xc6, 0xd0, 0x8b, 0x51, 0xc4, 0x9b, 0x24, 0x45, 0x0e,
+	0x14, 0x6a, 0xaf, 0xd9, 0x1b, 0xe8, 0x6d, 0x51, 0x89, 0x58, 0x28, 0xc1, 0xfb, 0x6e, 0xdb, 0xeb,
+	0x4f, 0x5c, 0xff, 0xa6, 0xd5, 0xa6, 0x27, 0xff, 0xac, 0xb1, 0x04, 0xa9, 0x92, 0x55, 0xb8, 0xbf,
+	0xc1, 0x1e, 0xc1, 0x20, 0xca, 0x52, 0x85, 0xa9, 0x5a, 0xa9, 0x2a, 0x47, 0x3e, 0xa0, 0xaf, 0xd8,
+	0x6f, 0xd8, 0xb2, 0xca, 0x91, 0x3d, 0x07, 0x67, 0x67, 0xc1, 0x34, 0xca, 0xe2, 0x24, 0x5d, 0xf3,
+	0x21, 0xd9, 0x46, 0x0d, 0x0f, 0x1a, 0xcc, 0xee, 0x83, 0x15, 0x5d, 0x96, 0xe9, 0x55, 0xc1, 0x0f,
+	0x5c, 0xc3, 0x1b, 0x86, 0x8d, 0x62, 0x4f, 0x60, 0x48, 0xd3, 0x6a, 0xd7, 0xdb, 0x88, 0xee, 0x0f,
+	0x08, 0x9e, 0x37, 0xe5, 0x31, 0xe8, 0x14, 0xc9, 0x4f, 0xe4, 0x0e, 0x05, 0xa4, 0x99, 0x9d, 0xc2,
+	0x50, 0x49, 0x11, 0xe1, 0x8a, 0x3e, 0xe9, 0x87, 0xe2, 0x87, 0x94, 0xf0, 0xe9, 0x3f, 0x12, 0x2e,
+	0x6b, 0xdf, 0x4c, 0xdb, 0x74, 0xca, 0x81, 0xba, 0x85, 0xd8, 0x33, 0x30, 0xbf, 0x4a, 0xb1, 0x45,
+	0xce, 0x5c, 0xc3, 0x3b, 0x98, 0x38, 0xb7, 0x56, 0xbc, 0xab, 0x79, 0xa8, 0x8f, 0xeb, 0xae, 0x0b,
+	0xbc, 0x2e, 0x31, 0x8d, 0x90, 0xdf, 0xd5, 0x5d, 0xef, 0x34, 0xe5, 0x93, 0x18, 0x27, 0x8a, 0x1f,
+	0x35, 0xf9, 0x48, 0xd5, 0xbf, 0xf0, 0x25, 0xc6, 0x6b, 0xe4, 0xf7, 0x5c, 0xc3, 0xeb, 0x85, 0x5a,
+	0x8c, 0x5f, 0xc3, 0xf0, 0x8f, 0xda, 0x99, 0x03, 0xed, 0x2b, 0xac, 0x9a, 0xd7, 0x5a, 0x8f, 0xf5,
+	0xc5, 0xef, 0x62, 0x53, 0x22, 0x3d, 0x55, 0x3b, 0xd4, 0xe2, 0x55, 0xeb, 0xa5, 0x31, 0x7e, 0x0b,
+	0x87, 0x7f, 0x25, 0xfa, 0x9f, 0x05, 0xc7, 0xef, 0xc1, 0xa4, 0x5c, 0xcc, 0x06, 0xf3, 0xf3, 0x62,
+	0x1a, 0x7e, 0x71, 0xee, 0xb0, 0x1e, 0x74, 0x3e, 0x7e, 0x0a, 0x16, 0x8e, 0x51, 0x4f, 0xf3, 0xe9,
+	0x72, 0xea, 0xb4, 0x58, 0x17, 0xda, 0xc1, 0x62, 0xee, 0xb4, 0x19, 0x80, 0x35, 0x0b, 0x83, 0xf9,
+	0xe9, 0xd2, 0xe9, 0xd0, 0x3c, 0x5d, 0xcc, 0x82, 0x0f, 0x8e, 0x79, 0x61, 0xd1, 0xbf, 0xee, 0xc5,
+	0xef, 0x01, 0x00, 0x58, 0xed, 0xe0, 0x8d, 0xac, 0x03, 0x00, 0x00,
 }
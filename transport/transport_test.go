@@ -1,42 +1,30 @@
-package transport
+package transport_test
 
 import (
+	"context"
+	"io"
 	"log"
-	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/chop-dbhi/nats-rpc/transport"
+	"github.com/chop-dbhi/nats-rpc/transport/transporttest"
 	"github.com/golang/protobuf/proto"
-	"github.com/nats-io/go-nats"
+	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/nats-io/nuid"
 )
 
-func newTransport(t testing.TB) Transport {
-	natsAddr := os.Getenv("NATS_ADDR")
-
-	if natsAddr == "" {
-		t.Fatal("no nats address provided")
-	}
-
-	tp, err := Connect(&nats.Options{
-		Url: natsAddr,
-	})
-
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	return tp
-}
-
 func TestPublish(t *testing.T) {
-	tp := newTransport(t)
-	defer tp.Close()
+	tp := transporttest.Connect(t)
 
 	// Publish a message.
-	msg, err := tp.Publish("_transport", nil, PublishCause("foobar"))
+	msg, err := tp.Publish(context.Background(), "_transport", nil, transport.PublishCause("foobar"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,15 +63,14 @@ func TestPublish(t *testing.T) {
 }
 
 func TestSubscribe(t *testing.T) {
-	tp := newTransport(t)
-	defer tp.Close()
+	tp := transporttest.Connect(t)
 
 	var (
-		msg *Message
+		msg *transport.Message
 		err error
 	)
 
-	hdlr := func(cmsg *Message) (proto.Message, error) {
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
 		if msg.Id != cmsg.Id {
 			t.Errorf("wrong id")
 		}
@@ -91,27 +78,26 @@ func TestSubscribe(t *testing.T) {
 	}
 
 	// Subscribe.
-	_, err = tp.Subscribe("_transport", hdlr, SubscribeQueue("_queue"))
+	_, err = tp.Subscribe("_transport", hdlr, transport.SubscribeQueue("_queue"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	msg, err = tp.Publish("_transport", nil)
+	msg, err = tp.Publish(context.Background(), "_transport", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestRequest(t *testing.T) {
-	tp := newTransport(t)
-	defer tp.Close()
+	tp := transporttest.Connect(t)
 
-	exp := &Message{
+	exp := &transport.Message{
 		Id: nuid.Next(),
 	}
 
 	// No-op reply.
-	hdlr := func(cmsg *Message) (proto.Message, error) {
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
 		if cmsg.Cause != "foobar" {
 			t.Errorf("expected foobar, got %s", cmsg.Cause)
 		}
@@ -121,14 +107,14 @@ func TestRequest(t *testing.T) {
 	}
 
 	// Subscribe.
-	_, err := tp.Subscribe("_transport", hdlr, SubscribeQueue("_queue"))
+	_, err := tp.Subscribe("_transport", hdlr, transport.SubscribeQueue("_queue"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Send request. The decoded message is expected to be equal to `exp`.
-	var rep Message
-	_, err = tp.Request("_transport", nil, &rep, RequestCause("foobar"))
+	var rep transport.Message
+	_, err = tp.Request(context.Background(), "_transport", nil, &rep, transport.RequestCause("foobar"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -139,10 +125,9 @@ func TestRequest(t *testing.T) {
 }
 
 func TestHandlerPanic(t *testing.T) {
-	tp := newTransport(t)
-	defer tp.Close()
+	tp := transporttest.Connect(t)
 
-	hdlr := func(cmsg *Message) (proto.Message, error) {
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
 		var i *int
 		log.Println(*i)
 		return nil, nil
@@ -153,8 +138,8 @@ func TestHandlerPanic(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var rep Message
-	_, err = tp.Request("_transport", nil, &rep)
+	var rep transport.Message
+	_, err = tp.Request(context.Background(), "_transport", nil, &rep)
 	if err == nil {
 		t.Fatal("expected error")
 		return
@@ -171,10 +156,9 @@ func TestHandlerPanic(t *testing.T) {
 }
 
 func TestCustomError(t *testing.T) {
-	tp := newTransport(t)
-	defer tp.Close()
+	tp := transporttest.Connect(t)
 
-	hdlr := func(_ *Message) (proto.Message, error) {
+	hdlr := func(_ context.Context, _ *transport.Message) (proto.Message, error) {
 		return nil, status.Error(codes.NotFound, "entity not found")
 	}
 
@@ -183,8 +167,8 @@ func TestCustomError(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var rep Message
-	_, err = tp.Request("_transport", nil, &rep)
+	var rep transport.Message
+	_, err = tp.Request(context.Background(), "_transport", nil, &rep)
 	if err == nil {
 		t.Fatal("expected error")
 		return
@@ -199,3 +183,760 @@ func TestCustomError(t *testing.T) {
 		t.Fatalf("expected %s code, got %s", codes.NotFound, sts.Code())
 	}
 }
+
+func TestRequestDeadline(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	exp, _ := ctx.Deadline()
+
+	hdlr := func(hctx context.Context, _ *transport.Message) (proto.Message, error) {
+		dl, ok := hctx.Deadline()
+		if !ok {
+			t.Errorf("expected deadline on handler context")
+		} else if !dl.Equal(exp) {
+			t.Errorf("expected deadline %s, got %s", exp, dl)
+		}
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	_, err = tp.Request(ctx, "_transport", nil, &rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	// The handler runs on another goroutine, so it reports what it saw.
+	type observed struct {
+		msg, cmsg *transport.Message
+		ok        bool
+	}
+
+	seen := make(chan observed, 1)
+
+	hdlr := func(ctx context.Context, cmsg *transport.Message) (proto.Message, error) {
+		msg, ok := transport.MessageFromContext(ctx)
+		seen <- observed{msg, cmsg, ok}
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	_, err = tp.Request(context.Background(), "_transport", nil, &rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := <-seen
+	if !o.ok {
+		t.Fatal("expected message in context")
+	}
+
+	if o.msg != o.cmsg {
+		t.Errorf("expected handler message in context")
+	}
+}
+
+func TestRequestMetadata(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	hdlr := func(ctx context.Context, _ *transport.Message) (proto.Message, error) {
+		md, ok := transport.IncomingMetadataFromContext(ctx)
+		if !ok {
+			t.Fatal("expected incoming metadata")
+		}
+
+		if md["tenant"] != "chop" {
+			t.Errorf("expected tenant chop, got %q", md["tenant"])
+		}
+
+		if md["locale"] != "en-US" {
+			t.Errorf("expected locale en-US, got %q", md["locale"])
+		}
+
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	_, err = tp.Request(context.Background(), "_transport", nil, &rep,
+		transport.RequestMetadata(transport.Metadata{"tenant": "chop"}),
+		transport.RequestMetadata(transport.Metadata{"locale": "en-US"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	var calls []string
+
+	client := func(name string) transport.ClientInterceptor {
+		return func(ctx context.Context, msg *transport.Message, invoker transport.Invoker) (*transport.Message, error) {
+			calls = append(calls, name)
+			if msg.Metadata == nil {
+				msg.Metadata = make(map[string]string)
+			}
+			msg.Metadata[name] = "1"
+			return invoker(ctx, msg)
+		}
+	}
+
+	server := func(name string) transport.ServerInterceptor {
+		return func(ctx context.Context, msg *transport.Message, hdlr transport.Handler) (proto.Message, error) {
+			calls = append(calls, name)
+			return hdlr(ctx, msg)
+		}
+	}
+
+	tp := transporttest.Connect(t,
+		transport.WithClientInterceptors(client("client-transport")),
+		transport.WithServerInterceptors(server("server-transport")),
+	)
+
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
+		calls = append(calls, "handler")
+
+		if cmsg.Metadata["client-transport"] != "1" || cmsg.Metadata["client-request"] != "1" {
+			t.Errorf("expected metadata set by client interceptors, got %v", cmsg.Metadata)
+		}
+
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr, transport.SubscribeInterceptors(server("server-subscribe")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	_, err = tp.Request(context.Background(), "_transport", nil, &rep, transport.RequestInterceptors(client("client-request")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"client-transport", "client-request", "server-transport", "server-subscribe", "handler"}
+	if len(calls) != len(exp) {
+		t.Fatalf("expected calls %v, got %v", exp, calls)
+	}
+
+	for i := range exp {
+		if calls[i] != exp[i] {
+			t.Fatalf("expected calls %v, got %v", exp, calls)
+		}
+	}
+}
+
+func TestRequestCodec(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
+		if cmsg.ContentType != transport.JSONCodec.ContentType() {
+			t.Errorf("expected json content type, got %q", cmsg.ContentType)
+		}
+
+		var req transport.Message
+		if err := cmsg.Decode(&req); err != nil {
+			return nil, err
+		}
+
+		return &transport.Message{Id: req.Id}, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	msg, err := tp.Request(context.Background(), "_transport", &transport.Message{Id: "abc"}, &rep, transport.RequestCodec(transport.JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.ContentType != transport.JSONCodec.ContentType() {
+		t.Errorf("expected json reply, got %q", msg.ContentType)
+	}
+
+	if rep.Id != "abc" {
+		t.Errorf("expected reply id abc, got %s", rep.Id)
+	}
+}
+
+func TestRequestCompression(t *testing.T) {
+	tp := transporttest.Connect(t, transport.WithCompressionThreshold(0))
+
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
+		if cmsg.ContentEncoding != transport.GzipCompressor.Name() {
+			t.Errorf("expected gzip content encoding, got %q", cmsg.ContentEncoding)
+		}
+
+		var req transport.Message
+		if err := cmsg.Decode(&req); err != nil {
+			return nil, err
+		}
+
+		return &transport.Message{Id: req.Id}, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	msg, err := tp.Request(context.Background(), "_transport", &transport.Message{Id: "abc"}, &rep, transport.RequestCompressor(transport.GzipCompressor))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.ContentEncoding != transport.GzipCompressor.Name() {
+		t.Errorf("expected gzip reply, got %q", msg.ContentEncoding)
+	}
+
+	if rep.Id != "abc" {
+		t.Errorf("expected reply id abc, got %s", rep.Id)
+	}
+}
+
+func TestRequestChunking(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	size := 3 * int(tp.Conn().MaxPayload())
+	payload := make(transport.RawMessage, size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
+		var req transport.RawMessage
+		if err := cmsg.Decode(&req); err != nil {
+			return nil, err
+		}
+
+		if len(req) != size {
+			t.Errorf("expected %d bytes, got %d", size, len(req))
+		}
+
+		// Echo the payload so the reply is chunked as well.
+		return &req, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.RawMessage
+	_, err = tp.Request(context.Background(), "_transport", &payload, &rep, transport.RequestCodec(transport.RawCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(rep) != string(payload) {
+		t.Errorf("reply does not match request")
+	}
+}
+
+func TestRequestMessageTooLarge(t *testing.T) {
+	srv := transporttest.NewServer(t)
+	tp := srv.Connect(t)
+
+	max := int(tp.Conn().MaxPayload())
+	stp := srv.Connect(t, transport.WithMaxMessageSize(2*max))
+
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
+		t.Error("handler called for message exceeding the maximum size")
+		return nil, nil
+	}
+
+	_, err := stp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The subscription must be registered before the request is sent
+	// using the other connection.
+	if err := stp.Conn().Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := make(transport.RawMessage, 3*max)
+	_, err = tp.Request(context.Background(), "_transport", &payload, nil, transport.RequestCodec(transport.RawCodec))
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected resource exhausted, got %v", err)
+	}
+}
+
+func TestRequestRetry(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	var ids []string
+
+	hdlr := func(_ context.Context, cmsg *transport.Message) (proto.Message, error) {
+		ids = append(ids, cmsg.Id)
+
+		if len(ids) < 3 {
+			return nil, status.Error(codes.Unavailable, "restarting")
+		}
+
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := transport.DefaultRetryPolicy
+	policy.InitialBackoff = time.Millisecond
+
+	_, err = tp.Request(context.Background(), "_transport", nil, nil, transport.RequestRetry(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(ids))
+	}
+
+	if ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Errorf("expected the same message id for all attempts, got %v", ids)
+	}
+
+	// Not retried without a policy.
+	ids = nil
+
+	_, err = tp.Request(context.Background(), "_transport", nil, nil)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable, got %v", err)
+	}
+
+	if len(ids) != 1 {
+		t.Errorf("expected 1 attempt, got %d", len(ids))
+	}
+}
+
+func TestRequestTimeoutStatus(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	// No subscriber, so the request times out.
+	_, err := tp.Request(context.Background(), "_transport.none", nil, nil, transport.RequestTimeout(10*time.Millisecond))
+
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	hdlr := func(_ context.Context, _ *transport.Message) (proto.Message, error) {
+		sts, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{
+			RetryDelay: &duration.Duration{Seconds: 5},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, sts.Err()
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tp.Request(context.Background(), "_transport", nil, nil)
+
+	sts, _ := status.FromError(err)
+	if sts.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected resource exhausted, got %v", err)
+	}
+
+	details := sts.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected 1 detail, got %d", len(details))
+	}
+
+	if info, ok := details[0].(*errdetails.RetryInfo); !ok || info.RetryDelay.Seconds != 5 {
+		t.Errorf("expected retry info, got %v", details[0])
+	}
+}
+
+func TestStream(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	hdlr := func(ctx context.Context, cmsg *transport.Message) (proto.Message, error) {
+		stream, ok := transport.ServerStreamFromContext(ctx)
+		if !ok {
+			t.Error("expected stream on handler context")
+			return nil, nil
+		}
+
+		for _, id := range []string{"a", "b", "c"} {
+			if err := stream.Send(&transport.Message{Id: id}); err != nil {
+				return nil, err
+			}
+		}
+
+		if cmsg.Cause == "fail" {
+			return nil, status.Error(codes.Aborted, "stream failed")
+		}
+
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := tp.Stream(context.Background(), "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids string
+	for {
+		var rep transport.Message
+		err := stream.Recv(&rep)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids += rep.Id
+	}
+
+	if ids != "abc" {
+		t.Errorf("expected abc, got %s", ids)
+	}
+
+	// The error of the handler ends the stream.
+	stream, err = tp.Stream(context.Background(), "_transport", nil, transport.RequestCause("fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		var rep transport.Message
+		if err = stream.Recv(&rep); err != nil {
+			break
+		}
+	}
+
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("expected aborted, got %v", err)
+	}
+}
+
+func TestBidiStream(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	// Replies with the ids received so far.
+	hdlr := func(ctx context.Context, _ *transport.Message) (proto.Message, error) {
+		stream, _ := transport.ServerStreamFromContext(ctx)
+
+		var ids string
+		for {
+			var req transport.Message
+			err := stream.Recv(&req)
+			if err == io.EOF {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			ids += req.Id
+			if err := stream.Send(&transport.Message{Id: ids}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := tp.Stream(context.Background(), "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := stream.Send(&transport.Message{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+
+		var rep transport.Message
+		if err := stream.Recv(&rep); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasSuffix(rep.Id, id) {
+			t.Errorf("expected reply ending in %s, got %s", id, rep.Id)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	if err := stream.Recv(&rep); err != io.EOF {
+		t.Errorf("expected end of stream, got %v", err)
+	}
+
+	if err := stream.Send(&transport.Message{}); err != io.EOF {
+		t.Errorf("expected send on ended stream to return EOF, got %v", err)
+	}
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	// Echoes the first message received.
+	hdlr := func(ctx context.Context, _ *transport.Message) (proto.Message, error) {
+		stream, _ := transport.ServerStreamFromContext(ctx)
+
+		var req transport.Message
+		if err := stream.Recv(&req); err != nil {
+			return nil, err
+		}
+
+		return nil, stream.Send(&req)
+	}
+
+	// Streams are not handled one at a time like requests.
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	first, err := tp.Stream(ctx, "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := tp.Stream(ctx, "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler of the first stream is still waiting while the second
+	// is served.
+	for _, stream := range []transport.ClientStream{second, first} {
+		if err := stream.Send(&transport.Message{Id: "a"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var rep transport.Message
+		if err := stream.Recv(&rep); err != nil {
+			t.Fatal(err)
+		}
+
+		if rep.Id != "a" {
+			t.Errorf("expected echo, got %q", rep.Id)
+		}
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	tp := transporttest.Connect(t, transport.WithStreamWindow(2))
+
+	var sent int32
+
+	hdlr := func(ctx context.Context, _ *transport.Message) (proto.Message, error) {
+		stream, _ := transport.ServerStreamFromContext(ctx)
+
+		for i := 0; i < 10; i++ {
+			if err := stream.Send(&transport.Message{}); err != nil {
+				return nil, err
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+
+		return nil, nil
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := tp.Stream(context.Background(), "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server may only send as many messages as the window allows until
+	// the client consumes them.
+	time.Sleep(100 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&sent); n != 2 {
+		t.Errorf("expected 2 messages sent before consuming, got %d", n)
+	}
+
+	var n int
+	for {
+		var rep transport.Message
+		err := stream.Recv(&rep)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+
+	if n != 10 {
+		t.Errorf("expected 10 messages, got %d", n)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	tp := transporttest.Connect(t)
+
+	cancelled := make(chan struct{})
+
+	hdlr := func(ctx context.Context, _ *transport.Message) (proto.Message, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	_, err := tp.Subscribe("_transport", hdlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := tp.Stream(ctx, "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the stream to be established.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected handler context to be cancelled")
+	}
+
+	var rep transport.Message
+	if err := stream.Recv(&rep); status.Code(err) != codes.Canceled {
+		t.Errorf("expected cancelled, got %v", err)
+	}
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	b := transport.NewMemoryBroker()
+
+	// The client does not send heartbeats, like one that went away.
+	client := b.Connect(transport.WithStreamWindow(1), transport.WithStreamHeartbeat(0))
+	defer client.Close()
+
+	server := b.Connect(transport.WithStreamHeartbeat(10 * time.Millisecond))
+	defer server.Close()
+
+	errs := make(chan error, 1)
+
+	hdlr := func(ctx context.Context, msg *transport.Message) (proto.Message, error) {
+		stream, _ := transport.ServerStreamFromContext(ctx)
+
+		var err error
+		if msg.Cause == "recv" {
+			err = stream.Recv(&transport.Message{})
+		} else {
+			// The second message waits for credit that is never granted.
+			for err == nil {
+				err = stream.Send(&transport.Message{})
+			}
+		}
+
+		errs <- err
+		return nil, err
+	}
+
+	if _, err := server.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cause := range []string{"send", "recv"} {
+		if _, err := client.Stream(context.Background(), "_transport", nil, transport.RequestCause(cause)); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-errs:
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("%s: expected unavailable, got %v", cause, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected the stream to end", cause)
+		}
+	}
+}
+
+func TestStreamHeartbeatIdle(t *testing.T) {
+	tp := transport.NewMemory(transport.WithStreamHeartbeat(10 * time.Millisecond))
+	defer tp.Close()
+
+	// Heartbeats keep a stream alive while neither side sends.
+	hdlr := func(ctx context.Context, _ *transport.Message) (proto.Message, error) {
+		stream, _ := transport.ServerStreamFromContext(ctx)
+
+		time.Sleep(100 * time.Millisecond)
+		return nil, stream.Send(&transport.Message{Id: "late"})
+	}
+
+	if _, err := tp.Subscribe("_transport", hdlr); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := tp.Stream(context.Background(), "_transport", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rep transport.Message
+	if err := stream.Recv(&rep); err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Id != "late" {
+		t.Errorf("expected reply, got %q", rep.Id)
+	}
+}
@@ -0,0 +1,75 @@
+// Package transporttest runs an embedded NATS server for tests so transports
+// can be tested without an external service.
+package transporttest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chop-dbhi/nats-rpc/transport"
+	"github.com/nats-io/gnatsd/server"
+	"github.com/nats-io/go-nats"
+)
+
+// ReadyTimeout is how long to wait for the server to accept connections.
+var ReadyTimeout = 10 * time.Second
+
+// Server is an embedded NATS server listening on a random local port.
+type Server struct {
+	*server.Server
+
+	// URL is the client URL of the server.
+	URL string
+}
+
+// NewServer starts an embedded NATS server. It is shut down when the test
+// ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := server.New(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	})
+
+	go s.Start()
+
+	if !s.ReadyForConnections(ReadyTimeout) {
+		s.Shutdown()
+		t.Fatal("nats server not ready for connections")
+	}
+
+	t.Cleanup(s.Shutdown)
+
+	return &Server{
+		Server: s,
+		URL:    fmt.Sprintf("nats://%s", s.Addr()),
+	}
+}
+
+// Connect returns a transport connected to the server. It is closed when the
+// test ends.
+func (s *Server) Connect(t testing.TB, opts ...transport.Option) transport.Transport {
+	t.Helper()
+
+	tp, err := transport.Connect(&nats.Options{
+		Url: s.URL,
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(tp.Close)
+
+	return tp
+}
+
+// Connect starts an embedded NATS server and returns a transport connected
+// to it. Both are torn down when the test ends.
+func Connect(t testing.TB, opts ...transport.Option) transport.Transport {
+	t.Helper()
+	return NewServer(t).Connect(t, opts...)
+}